@@ -0,0 +1,63 @@
+// Export what the jukebox played during a recording, from the journal of its record.
+//
+// Usage :
+//
+//	go run ./cmd/export -journal ./journal/1234.jsonl -format cue -o 1234.cue
+//
+// The format is either cue, audacity, otio or rpp (Reaper project)
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"roll20-audio-bouncer/internal/journal"
+	"roll20-audio-bouncer/internal/timeline"
+	"strings"
+)
+
+func main() {
+	path := flag.String("journal", "", "journal file of the record to export")
+	id := flag.String("record", "", "ID of the record, deduced from the journal file name if empty")
+	format := flag.String("format", string(timeline.FormatOtio), "export format, either cue, audacity, otio or rpp")
+	audio := flag.String("audio", "", "name of the recorded audio file the timeline is aligned with")
+	assets := flag.String("assets", "", "local directory the assets were downloaded to, assets are referenced by URL if empty")
+	reaper := flag.String("reaper-version", timeline.DefaultReaperVersion, "Reaper version of the rpp projects")
+	out := flag.String("o", "", "output file, the standard output if empty")
+	flag.Parse()
+	if *path == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *id == "" {
+		escaped := strings.TrimSuffix(filepath.Base(*path), filepath.Ext(*path))
+		var err error
+		if *id, err = url.PathUnescape(escaped); err != nil {
+			log.Fatalf("could not deduce the record ID from %s : %s", *path, err)
+		}
+	}
+
+	entries, err := journal.ReadFile(*path)
+	if err != nil {
+		log.Fatalf("could not read journal %s : %s", *path, err)
+	}
+	tl, err := timeline.FromJournal(*id, entries)
+	if err != nil {
+		log.Fatalf("could not build the timeline of record %s : %s", *id, err)
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("could not create %s : %s", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := timeline.Export(w, tl, timeline.Format(*format), timeline.Options{AudioFile: *audio, AssetDir: *assets, ReaperVersion: *reaper}); err != nil {
+		log.Fatalf("could not export record %s : %s", *id, err)
+	}
+}
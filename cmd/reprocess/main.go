@@ -0,0 +1,111 @@
+// Push a captured Roll20 session through the current jukebox syncer.
+//
+// Usage :
+//
+//	go run ./cmd/reprocess -capture ./capture/1234.jsonl -speed 10
+//
+// By default, the events computed by the syncer are printed on the standard output.
+// They can also be sent to a live audio mixer through a Dapr sidecar with -mixer dapr
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"roll20-audio-bouncer/internal/capture"
+	mixer_client "roll20-audio-bouncer/internal/mixer-client"
+	pb "roll20-audio-bouncer/proto"
+	jukebox_syncer "roll20-audio-bouncer/service/jukebox-syncer"
+	"strings"
+)
+
+func main() {
+	path := flag.String("capture", "", "capture file of the session to reprocess")
+	id := flag.String("record", "", "ID of the record to reprocess, deduced from the capture file name if empty")
+	speed := flag.Float64("speed", 1, "how many times faster than real time the states are pushed, 0 for no delay")
+	mixerKind := flag.String("mixer", "log", "where the events are sent, either log or dapr")
+	daprGrpcPort := flag.Int("dapr-grpc-port", 50001, "gRPC port of the Dapr sidecar, for the dapr mixer")
+	mixerId := flag.String("mixer-id", "live-audio-mixer", "Dapr app ID of the live audio mixer, for the dapr mixer")
+	// Same processing as the service, see its configuration
+	processing := jukebox_syncer.DefaultProcessingConfig()
+	flag.DurationVar(&processing.ReorderWindow, "reorder-window", processing.ReorderWindow, "how long states are held to be reordered, like REORDER_WINDOW_MS")
+	flag.StringVar(&processing.VolumeCurve, "volume-curve", processing.VolumeCurve, "how the volume sliders are mapped to amplitudes, like VOLUME_CURVE")
+	flag.StringVar(&processing.CampaignVolumeCurves, "campaign-volume-curves", processing.CampaignVolumeCurves, "volume curves of specific campaigns, like CAMPAIGN_VOLUME_CURVES")
+	flag.DurationVar(&processing.DebounceWindow, "debounce-window", processing.DebounceWindow, "how long volume and seek changes are held to be merged, like DEBOUNCE_WINDOW_MS")
+	flag.IntVar(&processing.FlappingToggles, "flapping-max-toggles", processing.FlappingToggles, "plays and stops making a track flapping, 0 to disable, like FLAPPING_MAX_TOGGLES")
+	flag.DurationVar(&processing.FlappingPeriod, "flapping-period", processing.FlappingPeriod, "period used to detect flapping tracks, like FLAPPING_PERIOD_MS")
+	flag.Parse()
+	if *path == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *id == "" {
+		escaped := strings.TrimSuffix(filepath.Base(*path), filepath.Ext(*path))
+		var err error
+		if *id, err = url.PathUnescape(escaped); err != nil {
+			log.Fatalf("could not deduce the record ID from %s : %s", *path, err)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	var mixer jukebox_syncer.MixerAPI
+	switch *mixerKind {
+	case "log":
+		mixer = &logMixer{}
+	case "dapr":
+		client, err := mixer_client.NewMixerClient(ctx, fmt.Sprintf("localhost:%d", *daprGrpcPort), *mixerId)
+		if err != nil {
+			log.Fatalf("could not connect to the mixer : %s", err)
+		}
+		mixer = client
+	default:
+		log.Fatalf("unknown mixer %s", *mixerKind)
+	}
+
+	entries, err := capture.ReadFile(*path)
+	if err != nil {
+		log.Fatalf("could not read capture %s : %s", *path, err)
+	}
+	opts, err := processing.Options()
+	if err != nil {
+		log.Fatalf("invalid processing options : %s", err)
+	}
+	syncer := jukebox_syncer.NewJukeboxSyncer(mixer, opts...)
+	stats, err := capture.Replay(ctx, *id, entries, syncer, *speed)
+	if stats != nil {
+		fmt.Fprintf(os.Stderr, "replayed %d states, %d rejected, %d skipped\n", stats.Replayed, stats.Rejected, stats.Skipped)
+	}
+	if err != nil {
+		log.Fatalf("could not reprocess record %s : %s", *id, err)
+	}
+}
+
+// Print every call made to the mixer, one JSON event per line
+type logMixer struct{}
+
+func (lm *logMixer) Start(id string) error {
+	fmt.Fprintf(os.Stderr, "start %s\n", id)
+	return nil
+}
+
+func (lm *logMixer) Stop(id string) (*pb.StopResult, error) {
+	fmt.Fprintf(os.Stderr, "stop %s\n", id)
+	return &pb.StopResult{StopReply: &pb.StopReply{}}, nil
+}
+
+func (lm *logMixer) Send(evt *pb.Event) error {
+	line, err := protojson.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(line))
+	return nil
+}
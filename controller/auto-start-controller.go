@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+)
+
+type AutoStartPolicy interface {
+	AutoStartCampaigns() []string
+	AllowAutoStart(id string) error
+	DisallowAutoStart(id string) error
+}
+
+// Manages the campaigns recorded automatically
+type AutoStartController struct {
+	policy AutoStartPolicy
+}
+
+func NewAutoStartController(policy AutoStartPolicy) *AutoStartController {
+	return &AutoStartController{
+		policy: policy,
+	}
+}
+
+func (ac *AutoStartController) List(c *gin.Context) {
+	c.JSON(http.StatusOK, ac.policy.AutoStartCampaigns())
+}
+
+func (ac *AutoStartController) Allow(c *gin.Context) {
+	id := c.Param("id")
+	if err := ac.policy.AllowAutoStart(id); err != nil {
+		slog.Error(fmt.Sprintf("[auto start controller] :: while allowing campaign %s : %s", id, err))
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	slog.Info(fmt.Sprintf("[auto start controller] :: campaign %s is now recorded automatically", id))
+	c.Status(http.StatusNoContent)
+}
+
+func (ac *AutoStartController) Disallow(c *gin.Context) {
+	id := c.Param("id")
+	if err := ac.policy.DisallowAutoStart(id); err != nil {
+		slog.Error(fmt.Sprintf("[auto start controller] :: while disallowing campaign %s : %s", id, err))
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	slog.Info(fmt.Sprintf("[auto start controller] :: campaign %s is no longer recorded automatically", id))
+	c.Status(http.StatusNoContent)
+}
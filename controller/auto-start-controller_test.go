@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAutoStartController_List(t *testing.T) {
+	mockPolicy := mockAutoStartPolicy{}
+	mockPolicy.On("AutoStartCampaigns").Return([]string{"1", "2"})
+	ctrl := NewAutoStartController(&mockPolicy)
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	ctrl.List(c)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `["1", "2"]`, w.Body.String())
+}
+
+func TestAutoStartController_Allow(t *testing.T) {
+	mockPolicy := mockAutoStartPolicy{}
+	mockPolicy.On("AllowAutoStart", "1").Return(nil)
+	mockPolicy.On("AllowAutoStart", "2").Return(fmt.Errorf("Test"))
+	ctrl := NewAutoStartController(&mockPolicy)
+	gin.SetMode(gin.TestMode)
+	for id, code := range map[string]int{"1": http.StatusNoContent, "2": http.StatusInternalServerError} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		ctrl.Allow(c)
+		assert.Equal(t, code, c.Writer.Status(), id)
+	}
+	mockPolicy.AssertExpectations(t)
+}
+
+func TestAutoStartController_Disallow(t *testing.T) {
+	mockPolicy := mockAutoStartPolicy{}
+	mockPolicy.On("DisallowAutoStart", "1").Return(nil)
+	mockPolicy.On("DisallowAutoStart", "2").Return(fmt.Errorf("Test"))
+	ctrl := NewAutoStartController(&mockPolicy)
+	gin.SetMode(gin.TestMode)
+	for id, code := range map[string]int{"1": http.StatusNoContent, "2": http.StatusInternalServerError} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		ctrl.Disallow(c)
+		assert.Equal(t, code, c.Writer.Status(), id)
+	}
+	mockPolicy.AssertExpectations(t)
+}
+
+type mockAutoStartPolicy struct {
+	mock.Mock
+}
+
+func (m *mockAutoStartPolicy) AutoStartCampaigns() []string {
+	args := m.Called()
+	return args.Get(0).([]string)
+}
+
+func (m *mockAutoStartPolicy) AllowAutoStart(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *mockAutoStartPolicy) DisallowAutoStart(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
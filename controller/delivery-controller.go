@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"roll20-audio-bouncer/internal/delivery"
+	"roll20-audio-bouncer/internal/mixer-client"
+)
+
+type DeadLetterLister interface {
+	DeadLetters(id string) []delivery.DeadLetter
+}
+
+type StreamMonitor interface {
+	StreamHealth() []mixer_client.StreamHealth
+}
+
+// Exposes the state of the event delivery to the mixer
+type DeliveryController struct {
+	deliveries DeadLetterLister
+	streams    StreamMonitor
+}
+
+func NewDeliveryController(deliveries DeadLetterLister, streams StreamMonitor) *DeliveryController {
+	return &DeliveryController{
+		deliveries: deliveries,
+		streams:    streams,
+	}
+}
+
+// List the events that couldn't be delivered to the mixer.
+// When no record ID is provided, the events of all records are listed
+func (dc *DeliveryController) DeadLetters(c *gin.Context) {
+	c.JSON(http.StatusOK, dc.deliveries.DeadLetters(c.Param("id")))
+}
+
+// Report the health of the event streams to the mixer.
+// When no record ID is provided, the streams of all records are listed
+func (dc *DeliveryController) Streams(c *gin.Context) {
+	id := c.Param("id")
+	health := dc.streams.StreamHealth()
+	if id == "" {
+		c.JSON(http.StatusOK, health)
+		return
+	}
+	for _, h := range health {
+		if h.RecordId == id {
+			c.JSON(http.StatusOK, h)
+			return
+		}
+	}
+	c.String(http.StatusNotFound, "no stream opened for record %s", id)
+}
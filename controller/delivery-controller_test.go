@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"roll20-audio-bouncer/internal/delivery"
+	"roll20-audio-bouncer/internal/mixer-client"
+	pb "roll20-audio-bouncer/proto"
+	"testing"
+)
+
+func TestDeliveryController_DeadLetters(t *testing.T) {
+	mockLister := mockDeadLetterLister{}
+	mockLister.On("DeadLetters", "1").Return([]delivery.DeadLetter{{Event: &pb.Event{RecordId: "1", EvtId: "a"}, Error: "Test"}})
+	ctrl := NewDeliveryController(&mockLister, &mockStreamMonitor{})
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	ctrl.DeadLetters(c)
+	mockLister.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var letters []delivery.DeadLetter
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &letters))
+	assert.Len(t, letters, 1)
+	assert.Equal(t, "a", letters[0].Event.EvtId)
+}
+
+// Without a record ID, all dead letters are listed
+func TestDeliveryController_AllDeadLetters(t *testing.T) {
+	mockLister := mockDeadLetterLister{}
+	mockLister.On("DeadLetters", "").Return([]delivery.DeadLetter{})
+	ctrl := NewDeliveryController(&mockLister, &mockStreamMonitor{})
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	ctrl.DeadLetters(c)
+	mockLister.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, "[]", w.Body.String())
+}
+
+type mockDeadLetterLister struct {
+	mock.Mock
+}
+
+func (m *mockDeadLetterLister) DeadLetters(id string) []delivery.DeadLetter {
+	args := m.Called(id)
+	return args.Get(0).([]delivery.DeadLetter)
+}
+
+func TestDeliveryController_Streams(t *testing.T) {
+	mockMonitor := mockStreamMonitor{}
+	mockMonitor.On("StreamHealth").Return([]mixer_client.StreamHealth{{RecordId: "1", Connected: true}, {RecordId: "2"}})
+	ctrl := NewDeliveryController(&mockDeadLetterLister{}, &mockMonitor)
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	ctrl.Streams(c)
+	mockMonitor.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var health []mixer_client.StreamHealth
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &health))
+	assert.Len(t, health, 2)
+}
+
+func TestDeliveryController_RecordStream(t *testing.T) {
+	mockMonitor := mockStreamMonitor{}
+	mockMonitor.On("StreamHealth").Return([]mixer_client.StreamHealth{{RecordId: "1", Connected: true}, {RecordId: "2"}})
+	ctrl := NewDeliveryController(&mockDeadLetterLister{}, &mockMonitor)
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "2"}}
+	ctrl.Streams(c)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var health mixer_client.StreamHealth
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &health))
+	assert.Equal(t, "2", health.RecordId)
+	assert.False(t, health.Connected)
+}
+
+func TestDeliveryController_UnknownRecordStream(t *testing.T) {
+	mockMonitor := mockStreamMonitor{}
+	mockMonitor.On("StreamHealth").Return([]mixer_client.StreamHealth{})
+	ctrl := NewDeliveryController(&mockDeadLetterLister{}, &mockMonitor)
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	ctrl.Streams(c)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+type mockStreamMonitor struct {
+	mock.Mock
+}
+
+func (m *mockStreamMonitor) StreamHealth() []mixer_client.StreamHealth {
+	args := m.Called()
+	return args.Get(0).([]mixer_client.StreamHealth)
+}
@@ -1,26 +1,58 @@
 package controller
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"io"
 	"log/slog"
 	"net/http"
+	"roll20-audio-bouncer/internal/capture"
 	"roll20-audio-bouncer/service/jukebox-syncer"
+	"time"
 )
 
 type StateHandler interface {
 	Handle(r *jukebox_syncer.R20State) error
 	Start(id string) error
-	Stop(id string) error
+	Stop(id string) (*jukebox_syncer.RecordOutput, error)
+	Output(id string) (*jukebox_syncer.RecordOutput, error)
+	Stats(id string) (*jukebox_syncer.RecordStats, error)
+	Heartbeat(id string) error
+	Resync(id string) error
 }
+
+// Keeps every payload posted to the ingest endpoint
+type StateCapture interface {
+	Append(id string, entry capture.Entry) error
+}
+
 type EventController struct {
 	syncer StateHandler
+	// Optional
+	capture StateCapture
+}
+
+// Optional configuration of the event controller
+type EventControllerOption func(*EventController)
+
+// Capture every state received, accepted or not, so that sessions can be reprocessed later on
+func WithStateCapture(capture StateCapture) EventControllerOption {
+	return func(ec *EventController) {
+		ec.capture = capture
+	}
 }
 
-func NewEventController(syncer StateHandler) *EventController {
-	return &EventController{
+func NewEventController(syncer StateHandler, opts ...EventControllerOption) *EventController {
+	ec := &EventController{
 		syncer: syncer,
 	}
+	for _, opt := range opts {
+		opt(ec)
+	}
+	return ec
 }
 func (ec *EventController) Start(c *gin.Context) {
 	var target jukebox_syncer.RecPayload
@@ -41,6 +73,7 @@ func (ec *EventController) Start(c *gin.Context) {
 	slog.Info(fmt.Sprintf("[evt controller] :: starting an new record with id %s", target.Id))
 }
 
+// Stop a record, answering with what the mixer produced
 func (ec *EventController) Stop(c *gin.Context) {
 	var target jukebox_syncer.RecPayload
 
@@ -50,27 +83,124 @@ func (ec *EventController) Stop(c *gin.Context) {
 		return
 	}
 
-	if err := ec.syncer.Stop(target.Id); err != nil {
+	output, err := ec.syncer.Stop(target.Id)
+	if err != nil {
 		slog.Error(fmt.Sprintf("[evt controller] :: while stopping existing record with id %s : %s", target.Id, err))
 		c.String(http.StatusInternalServerError, err.Error())
 		return
-	} else {
-		c.String(http.StatusAccepted, "")
 	}
+	c.JSON(http.StatusOK, output)
 	slog.Info(fmt.Sprintf("[evt controller] :: stopping existing record with id %s", target.Id))
 }
 
 func (ec *EventController) Handle(c *gin.Context) {
 	var target jukebox_syncer.R20State
+	receivedAt := time.Now()
 
-	if err := c.BindJSON(&target); err != nil {
+	body, err := readBody(c)
+	if err == nil {
+		err = binding.JSON.BindBody(body, &target)
+	}
+	if err != nil {
 		slog.Info(fmt.Sprintf("[evt controller] :: invalid body provided: %s !", err.Error()))
+		ec.captureState(body, receivedAt, err)
 		c.String(http.StatusBadRequest, `invalid body provided: %s !`, err.Error())
 		return
 	}
 	slog.Info(fmt.Sprintf("[evt controller] :: processing %+v", target))
-	if err := ec.syncer.Handle(&target); err != nil {
+	target.ReceivedAt = receivedAt
+	// The worker of the record may still reject the state, it is captured once it is done with it
+	target.Outcome = func(err error) { ec.captureState(body, receivedAt, err) }
+	err = ec.syncer.Handle(&target)
+	if err != nil {
 		slog.Error(fmt.Sprintf("[evt controller] :: while processing %v : %s", target, err))
+		ec.captureState(body, receivedAt, err)
+	}
+	c.String(http.StatusAccepted, "")
+}
+
+// Capture a received payload, and why it was rejected if it was
+func (ec *EventController) captureState(body []byte, receivedAt time.Time, reason error) {
+	if ec.capture == nil || len(body) == 0 {
+		return
+	}
+	// The payload may not be a valid state, but still carry a record ID
+	var target struct {
+		Rid string `json:"rId"`
+	}
+	_ = json.Unmarshal(body, &target)
+	if target.Rid == "" {
+		target.Rid = capture.UnknownRecord
+	}
+	if err := ec.capture.Append(target.Rid, capture.NewEntry(receivedAt, body, reason)); err != nil {
+		slog.Warn(fmt.Sprintf("[evt controller] :: could not capture state of record %s : %s", target.Rid, err))
+	}
+}
+
+func readBody(c *gin.Context) ([]byte, error) {
+	if c.Request == nil || c.Request.Body == nil {
+		return nil, fmt.Errorf("invalid request")
+	}
+	return io.ReadAll(c.Request.Body)
+}
+
+// Keep a record active, even if its jukebox doesn't change
+func (ec *EventController) Heartbeat(c *gin.Context) {
+	var target jukebox_syncer.RecPayload
+
+	if err := c.BindJSON(&target); err != nil {
+		slog.Info(fmt.Sprintf("[evt controller] :: invalid body provided: %s !", err.Error()))
+		c.String(http.StatusBadRequest, `invalid body provided: %s !`, err.Error())
+		return
+	}
+
+	if err := ec.syncer.Heartbeat(target.Id); err != nil {
+		slog.Debug(fmt.Sprintf("[evt controller] :: heartbeat of record with id %s : %s", target.Id, err))
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	c.String(http.StatusAccepted, "")
+}
+
+func (ec *EventController) Stats(c *gin.Context) {
+	id := c.Param("id")
+	stats, err := ec.syncer.Stats(id)
+	if err != nil {
+		slog.Info(fmt.Sprintf("[evt controller] :: while retrieving stats of record with id %s : %s", id, err))
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// Retrieve what the mixer produced for the last recording of a record
+func (ec *EventController) Output(c *gin.Context) {
+	id := c.Param("id")
+	output, err := ec.syncer.Output(id)
+	if errors.Is(err, jukebox_syncer.ErrNoOutput) {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("[evt controller] :: while retrieving output of record with id %s : %s", id, err))
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, output)
+}
+
+// Send the full state of a record to the mixer
+func (ec *EventController) Resync(c *gin.Context) {
+	id := c.Param("id")
+	err := ec.syncer.Resync(id)
+	if errors.Is(err, jukebox_syncer.ErrNotStarted) {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("[evt controller] :: while resyncing record with id %s : %s", id, err))
+		c.String(http.StatusBadGateway, err.Error())
+		return
 	}
 	c.String(http.StatusAccepted, "")
 }
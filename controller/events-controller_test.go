@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/mock"
 	"net/http"
 	"net/http/httptest"
+	"roll20-audio-bouncer/internal/capture"
 	jukebox_syncer "roll20-audio-bouncer/service/jukebox-syncer"
 	"testing"
 	"time"
@@ -68,6 +69,38 @@ func TestEventController_HandleError(t *testing.T) {
 	assert.Equal(t, http.StatusAccepted, w.Code)
 }
 
+// Accepted and rejected states must both be captured, with the rejection reason
+func TestEventController_HandleCapture(t *testing.T) {
+	mockHandler := mockStateHandler{}
+	mockHandler.On("Handle", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		args.Get(0).(*jukebox_syncer.R20State).Outcome(nil)
+	}).Once()
+	mockHandler.On("Handle", mock.Anything).Return(fmt.Errorf("Test")).Once()
+	// Rejected later on by the worker of the record
+	mockHandler.On("Handle", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		args.Get(0).(*jukebox_syncer.R20State).Outcome(fmt.Errorf("Late"))
+	}).Once()
+	mockCapture := mockStateCapture{}
+	mockCapture.On("Append", "1", mock.MatchedBy(func(e capture.Entry) bool { return e.Accepted })).Return(nil).Once()
+	mockCapture.On("Append", "1", mock.MatchedBy(func(e capture.Entry) bool { return !e.Accepted && e.Reason == "Test" })).Return(nil).Once()
+	mockCapture.On("Append", "1", mock.MatchedBy(func(e capture.Entry) bool { return !e.Accepted && e.Reason == "Late" })).Return(nil).Once()
+	mockCapture.On("Append", capture.UnknownRecord, mock.MatchedBy(func(e capture.Entry) bool { return e.Raw == "{" })).Return(nil).Once()
+	ctrl := NewEventController(&mockHandler, WithStateCapture(&mockCapture))
+	gin.SetMode(gin.TestMode)
+	for i := 0; i < 3; i++ {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		setJsonAsBody(t, c, sampleState)
+		ctrl.Handle(c)
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/", bytes.NewBufferString("{"))
+	ctrl.Handle(c)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockHandler.AssertExpectations(t)
+	mockCapture.AssertExpectations(t)
+}
+
 func TestEventController_StartBadRequest(t *testing.T) {
 	mockHandler := mockStateHandler{}
 	ctrl := NewEventController(&mockHandler)
@@ -118,7 +151,7 @@ func TestEventController_StopBadRequest(t *testing.T) {
 
 func TestEventController_StopOkRequest(t *testing.T) {
 	mockHandler := mockStateHandler{}
-	mockHandler.On("Stop", mock.Anything).Return(nil)
+	mockHandler.On("Stop", mock.Anything).Return(&jukebox_syncer.RecordOutput{RecordId: "1", StorageKey: "records/1.ogg"}, nil)
 	ctrl := NewEventController(&mockHandler)
 	gin.SetMode(gin.TestMode)
 	w := httptest.NewRecorder()
@@ -126,12 +159,15 @@ func TestEventController_StopOkRequest(t *testing.T) {
 	setJsonAsBody(t, c, sampleRecPayload)
 	ctrl.Stop(c)
 	mockHandler.AssertExpectations(t)
-	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var output jukebox_syncer.RecordOutput
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &output))
+	assert.Equal(t, "records/1.ogg", output.StorageKey)
 }
 
 func TestEventController_StopError(t *testing.T) {
 	mockHandler := mockStateHandler{}
-	mockHandler.On("Stop", mock.Anything).Return(fmt.Errorf("Test"))
+	mockHandler.On("Stop", mock.Anything).Return(nil, fmt.Errorf("Test"))
 	ctrl := NewEventController(&mockHandler)
 	gin.SetMode(gin.TestMode)
 	w := httptest.NewRecorder()
@@ -142,6 +178,140 @@ func TestEventController_StopError(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 }
 
+func TestEventController_StatsOkRequest(t *testing.T) {
+	mockHandler := mockStateHandler{}
+	mockHandler.On("Stats", "1").Return(&jukebox_syncer.RecordStats{LateStates: 2}, nil)
+	ctrl := NewEventController(&mockHandler)
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	ctrl.Stats(c)
+	mockHandler.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var stats jukebox_syncer.RecordStats
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, 2, stats.LateStates)
+}
+
+func TestEventController_StatsUnknownRecord(t *testing.T) {
+	mockHandler := mockStateHandler{}
+	mockHandler.On("Stats", "1").Return(nil, fmt.Errorf("Test"))
+	ctrl := NewEventController(&mockHandler)
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	ctrl.Stats(c)
+	mockHandler.AssertExpectations(t)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestEventController_Output(t *testing.T) {
+	mockHandler := mockStateHandler{}
+	mockHandler.On("Output", "1").Return(&jukebox_syncer.RecordOutput{RecordId: "1", DurationMs: 1000}, nil)
+	ctrl := NewEventController(&mockHandler)
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	ctrl.Output(c)
+	mockHandler.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var output jukebox_syncer.RecordOutput
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &output))
+	assert.Equal(t, int64(1000), output.DurationMs)
+}
+
+func TestEventController_OutputUnknownRecord(t *testing.T) {
+	mockHandler := mockStateHandler{}
+	mockHandler.On("Output", "1").Return(nil, fmt.Errorf("Test : %w", jukebox_syncer.ErrNoOutput))
+	ctrl := NewEventController(&mockHandler)
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	ctrl.Output(c)
+	mockHandler.AssertExpectations(t)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestEventController_Heartbeat(t *testing.T) {
+	mockHandler := mockStateHandler{}
+	mockHandler.On("Heartbeat", "1").Return(nil)
+	ctrl := NewEventController(&mockHandler)
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	setJsonAsBody(t, c, sampleRecPayload)
+	ctrl.Heartbeat(c)
+	mockHandler.AssertExpectations(t)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
+
+func TestEventController_HeartbeatBadRequest(t *testing.T) {
+	mockHandler := mockStateHandler{}
+	ctrl := NewEventController(&mockHandler)
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	ctrl.Heartbeat(c)
+	mockHandler.AssertNotCalled(t, "Heartbeat", mock.Anything)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestEventController_HeartbeatUnknownRecord(t *testing.T) {
+	mockHandler := mockStateHandler{}
+	mockHandler.On("Heartbeat", "1").Return(fmt.Errorf("Test"))
+	ctrl := NewEventController(&mockHandler)
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	setJsonAsBody(t, c, sampleRecPayload)
+	ctrl.Heartbeat(c)
+	mockHandler.AssertExpectations(t)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestEventController_Resync(t *testing.T) {
+	mockHandler := mockStateHandler{}
+	mockHandler.On("Resync", "1").Return(nil)
+	ctrl := NewEventController(&mockHandler)
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	ctrl.Resync(c)
+	mockHandler.AssertExpectations(t)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
+
+func TestEventController_ResyncUnknownRecord(t *testing.T) {
+	mockHandler := mockStateHandler{}
+	mockHandler.On("Resync", "1").Return(fmt.Errorf("record 1 : %w", jukebox_syncer.ErrNotStarted))
+	ctrl := NewEventController(&mockHandler)
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	ctrl.Resync(c)
+	mockHandler.AssertExpectations(t)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestEventController_ResyncMixerError(t *testing.T) {
+	mockHandler := mockStateHandler{}
+	mockHandler.On("Resync", "1").Return(fmt.Errorf("Test"))
+	ctrl := NewEventController(&mockHandler)
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	ctrl.Resync(c)
+	mockHandler.AssertExpectations(t)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
+
 // Set the payload as the JSON body of c
 func setJsonAsBody(t *testing.T, c *gin.Context, payload any) {
 	buf, err := json.Marshal(payload)
@@ -153,6 +323,15 @@ func setJsonAsBody(t *testing.T, c *gin.Context, payload any) {
 	c.Request.Header.Set("Content-Type", "application/json")
 }
 
+type mockStateCapture struct {
+	mock.Mock
+}
+
+func (m *mockStateCapture) Append(id string, entry capture.Entry) error {
+	args := m.Called(id, entry)
+	return args.Error(0)
+}
+
 type mockStateHandler struct {
 	mock.Mock
 }
@@ -166,7 +345,30 @@ func (m *mockStateHandler) Start(id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
-func (m *mockStateHandler) Stop(id string) error {
+func (m *mockStateHandler) Stop(id string) (*jukebox_syncer.RecordOutput, error) {
+	args := m.Called(id)
+	output, _ := args.Get(0).(*jukebox_syncer.RecordOutput)
+	return output, args.Error(1)
+}
+
+func (m *mockStateHandler) Output(id string) (*jukebox_syncer.RecordOutput, error) {
+	args := m.Called(id)
+	output, _ := args.Get(0).(*jukebox_syncer.RecordOutput)
+	return output, args.Error(1)
+}
+
+func (m *mockStateHandler) Heartbeat(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *mockStateHandler) Resync(id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
+
+func (m *mockStateHandler) Stats(id string) (*jukebox_syncer.RecordStats, error) {
+	args := m.Called(id)
+	stats, _ := args.Get(0).(*jukebox_syncer.RecordStats)
+	return stats, args.Error(1)
+}
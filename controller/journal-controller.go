@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"roll20-audio-bouncer/internal/journal"
+	"roll20-audio-bouncer/internal/timeline"
+)
+
+type JournalReader interface {
+	Entries(id string) ([]journal.Entry, error)
+}
+
+// Exposes the journal of the calls made to the mixer
+type JournalController struct {
+	journal JournalReader
+	// Where journaled events are replayed
+	mixer journal.Mixer
+}
+
+func NewJournalController(journal JournalReader, mixer journal.Mixer) *JournalController {
+	return &JournalController{
+		journal: journal,
+		mixer:   mixer,
+	}
+}
+
+// List the calls made to the mixer for a record, in order
+func (jc *JournalController) Events(c *gin.Context) {
+	id := c.Param("id")
+	entries, err := jc.journal.Entries(id)
+	if err != nil {
+		slog.Error(fmt.Sprintf("[journal controller] :: while reading journal of record %s : %s", id, err))
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if entries == nil {
+		c.String(http.StatusNotFound, "no journal for record %s", id)
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// Send the events of the last recording of a record to the mixer again, into the record given by the into parameter.
+// By default, the events are replayed into the "<id>-replay" record
+func (jc *JournalController) Replay(c *gin.Context) {
+	id := c.Param("id")
+	target := c.DefaultQuery("into", id+"-replay")
+	if target == id {
+		c.String(http.StatusBadRequest, "events can't be replayed into their own record")
+		return
+	}
+	entries, err := jc.journal.Entries(id)
+	if err != nil {
+		slog.Error(fmt.Sprintf("[journal controller] :: while reading journal of record %s : %s", id, err))
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if entries == nil {
+		c.String(http.StatusNotFound, "no journal for record %s", id)
+		return
+	}
+	result, err := journal.Replay(entries, target, jc.mixer)
+	if errors.Is(err, journal.ErrNothingToReplay) {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("[journal controller] :: while replaying journal of record %s : %s", id, err))
+		c.String(http.StatusBadGateway, err.Error())
+		return
+	}
+	slog.Info(fmt.Sprintf("[journal controller] :: replayed %d events of record %s into %s", result.Events, id, target))
+	c.JSON(http.StatusOK, result)
+}
+
+// Export what the jukebox played during the last recording of a record.
+// The format is either cue, audacity, otio or rpp. The name of the recorded audio file,
+// and the local directory the assets were downloaded to can also be provided
+func (jc *JournalController) Timeline(c *gin.Context) {
+	id := c.Param("id")
+	format := timeline.Format(c.DefaultQuery("format", string(timeline.FormatOtio)))
+	entries, err := jc.journal.Entries(id)
+	if err != nil {
+		slog.Error(fmt.Sprintf("[journal controller] :: while reading journal of record %s : %s", id, err))
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if entries == nil {
+		c.String(http.StatusNotFound, "no journal for record %s", id)
+		return
+	}
+	tl, err := timeline.FromJournal(id, entries)
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	var buf bytes.Buffer
+	if err := timeline.Export(&buf, tl, format, timeline.Options{AudioFile: c.Query("audio"), AssetDir: c.Query("assets"), ReaperVersion: c.Query("reaperVersion")}); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, url.PathEscape(id)+format.Extension()))
+	c.Data(http.StatusOK, format.ContentType(), buf.Bytes())
+}
@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"roll20-audio-bouncer/internal/journal"
+	pb "roll20-audio-bouncer/proto"
+	"testing"
+	"time"
+)
+
+func TestJournalController_Events(t *testing.T) {
+	mockReader := mockJournalReader{}
+	mockReader.On("Entries", "1").Return([]journal.Entry{
+		{Kind: journal.KindStart},
+		{Kind: journal.KindEvent, Event: &pb.Event{RecordId: "1", EvtId: "a"}, Error: "Test"},
+	}, nil)
+	w := serveJournal(&mockReader, "1")
+	mockReader.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var entries []journal.Entry
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "a", entries[1].Event.EvtId)
+	assert.Equal(t, "Test", entries[1].Error)
+}
+
+func TestJournalController_EventsNotFound(t *testing.T) {
+	mockReader := mockJournalReader{}
+	mockReader.On("Entries", "1").Return([]journal.Entry(nil), nil)
+	w := serveJournal(&mockReader, "1")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestJournalController_EventsError(t *testing.T) {
+	mockReader := mockJournalReader{}
+	mockReader.On("Entries", "1").Return([]journal.Entry(nil), fmt.Errorf("Test"))
+	w := serveJournal(&mockReader, "1")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestJournalController_Timeline(t *testing.T) {
+	refDate := time.Now()
+	mockReader := mockJournalReader{}
+	mockReader.On("Entries", "1").Return([]journal.Entry{
+		{Date: refDate, Kind: journal.KindStart},
+		{Date: refDate.Add(time.Second), Kind: journal.KindEvent, Event: &pb.Event{EvtId: "a", Type: pb.EventType_PLAY}, Title: "Tavern"},
+	}, nil)
+	ctrl := NewJournalController(&mockReader, &mockReplayMixer{})
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	c.Request, _ = http.NewRequest("GET", "/?format=audacity", nil)
+	ctrl.Timeline(c)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1.000000\t1.000000\tTavern (0.0 dB)\n", w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "1.txt")
+}
+
+func TestJournalController_TimelineErrors(t *testing.T) {
+	mockReader := mockJournalReader{}
+	mockReader.On("Entries", "1").Return([]journal.Entry{{Kind: journal.KindStart}}, nil)
+	mockReader.On("Entries", "2").Return([]journal.Entry{{Kind: journal.KindStop}}, nil)
+	mockReader.On("Entries", "3").Return([]journal.Entry(nil), nil)
+	ctrl := NewJournalController(&mockReader, &mockReplayMixer{})
+	gin.SetMode(gin.TestMode)
+	for id, code := range map[string]int{"1": http.StatusBadRequest, "2": http.StatusNotFound, "3": http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		c.Request, _ = http.NewRequest("GET", "/?format=mp3", nil)
+		ctrl.Timeline(c)
+		assert.Equal(t, code, w.Code, id)
+	}
+}
+
+func TestJournalController_Replay(t *testing.T) {
+	mockReader := mockJournalReader{}
+	mockReader.On("Entries", "1").Return([]journal.Entry{
+		{Kind: journal.KindStart},
+		{Kind: journal.KindEvent, Event: &pb.Event{RecordId: "1", EvtId: "a", Type: pb.EventType_PLAY}},
+		{Kind: journal.KindStop},
+	}, nil)
+	mockMixer := mockReplayMixer{}
+	mockMixer.On("Start", "1-replay").Return(nil)
+	mockMixer.On("Send", mock.MatchedBy(func(evt *pb.Event) bool { return evt.RecordId == "1-replay" })).Return(nil)
+	mockMixer.On("Stop", "1-replay").Return(&pb.StopResult{StopReply: &pb.StopReply{StorageKey: "records/1-replay.ogg"}}, nil)
+	ctrl := NewJournalController(&mockReader, &mockMixer)
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: "1"}}
+	c.Request, _ = http.NewRequest("POST", "/", nil)
+	ctrl.Replay(c)
+	mockMixer.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var result journal.ReplayResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, 1, result.Events)
+	assert.Equal(t, "records/1-replay.ogg", result.StorageKey)
+}
+
+func TestJournalController_ReplayErrors(t *testing.T) {
+	mockReader := mockJournalReader{}
+	mockReader.On("Entries", "1").Return([]journal.Entry{{Kind: journal.KindStop}}, nil)
+	mockReader.On("Entries", "2").Return([]journal.Entry(nil), nil)
+	mockReader.On("Entries", "3").Return([]journal.Entry{{Kind: journal.KindStart}}, nil)
+	mockMixer := mockReplayMixer{}
+	mockMixer.On("Start", "3-replay").Return(fmt.Errorf("Test"))
+	ctrl := NewJournalController(&mockReader, &mockMixer)
+	gin.SetMode(gin.TestMode)
+	for _, tc := range []struct {
+		id, query string
+		code      int
+	}{{"1", "", http.StatusNotFound}, {"2", "", http.StatusNotFound}, {"3", "", http.StatusBadGateway}, {"1", "?into=1", http.StatusBadRequest}} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{{Key: "id", Value: tc.id}}
+		c.Request, _ = http.NewRequest("POST", "/"+tc.query, nil)
+		ctrl.Replay(c)
+		assert.Equal(t, tc.code, w.Code, tc.id+tc.query)
+	}
+}
+
+func serveJournal(reader JournalReader, id string) *httptest.ResponseRecorder {
+	ctrl := NewJournalController(reader, &mockReplayMixer{})
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "id", Value: id}}
+	ctrl.Events(c)
+	return w
+}
+
+type mockJournalReader struct {
+	mock.Mock
+}
+
+func (m *mockJournalReader) Entries(id string) ([]journal.Entry, error) {
+	args := m.Called(id)
+	return args.Get(0).([]journal.Entry), args.Error(1)
+}
+
+type mockReplayMixer struct {
+	mock.Mock
+}
+
+func (m *mockReplayMixer) Start(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *mockReplayMixer) Send(evt *pb.Event) error {
+	args := m.Called(evt)
+	return args.Error(0)
+}
+
+func (m *mockReplayMixer) Stop(id string) (*pb.StopResult, error) {
+	args := m.Called(id)
+	reply, _ := args.Get(0).(*pb.StopResult)
+	return reply, args.Error(1)
+}
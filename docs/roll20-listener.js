@@ -7,13 +7,39 @@ window.Jukebox.scanForNewPlays = function() {
             .catch(err => console.log(`error sending jk state ${err}`))
     }
 }
+// Keep the record active while the game is open, even if the jukebox doesn't change
+const heartbeatIntervalMs = 30 * 1000
+setInterval(() => {
+    if(!window.is_gm){
+        return
+    }
+    $.ajax({
+        url: '<JKBSYNC_URL>'.replace(/\/evt$/, '/heartbeat'),
+        type: 'POST',
+        contentType: 'application/json',
+        data: JSON.stringify({ id: String(window.campaign_id) })
+    }).fail( (xhr, textStatus, errorThrown) => console.log(`Error while sending jk heartbeat : ${errorThrown}`))
+}, heartbeatIntervalMs)
 let srcMap = new Map()
+// Monotonic sequence number, allowing the backend to order states sharing a date
+let seq = 0
+// Roll20 playlist modes, as stored in the jukebox folder
+const playlistModes = {
+    a: "playthrough",
+    s: "shuffle",
+    b: "together",
+    l: "loop",
+}
 async function sendJukeboxState(){
+    const playlists = getPlaylists()
+    const playlistIds = getPlaylistIds(playlists)
     let models = Jukebox.playlist.map(async (p) => {
         const attr = p.attributes
         return {
             title: attr.title,
             url: await getSrc(p),
+            trackId: String(p.get('track_id')),
+            playlistId: playlistIds.get(p.id) || "",
             loop : attr.loop,
             playing : attr.playing,
             volume : attr.volume,
@@ -26,14 +52,52 @@ async function sendJukeboxState(){
     let payload =  {
         uId : String(window.d20_player_id),
         tracks: filtered,
+        playlists: playlists.map(p => ({
+            id: p.id,
+            title: p.n,
+            mode: playlistModes[p.s] || "single",
+        })),
         rId: String(window.campaign_id),
-        date : new Date().toJSON()
+        date : new Date().toJSON(),
+        seq: ++seq,
+        masterVolume: getMasterVolume(),
     }
     console.log(payload)
-    $.post('<JKBSYNC_URL>')
+    $.ajax({ url: '<JKBSYNC_URL>', type: 'POST', contentType: 'application/json', data: JSON.stringify(payload) })
         .done( (msg) => console.log(msg))
         .fail( (xhr, textStatus, errorThrown) => console.log(`Error while sending jk state : ${errorThrown}`))
 
+    // Master volume slider of the jukebox, from 0 to 100. Left out if it can't be found
+    function getMasterVolume() {
+        try {
+            const volume = $('#masterVolume').slider('value')
+            return typeof volume === 'number' ? volume : undefined
+        } catch (e) {
+            return undefined
+        }
+    }
+
+    // Retrieve all the playlists of the jukebox
+    function getPlaylists() {
+        let folder = []
+        try {
+            folder = JSON.parse(Campaign.get('jukeboxfolder') || '[]')
+        } catch (e) {
+            console.log(`jk state : Could not parse playlists ${e}`)
+        }
+        // Loose tracks are stored as plain IDs, playlists as objects
+        return folder.filter(item => typeof item === 'object' && Array.isArray(item.i))
+    }
+
+    // Map each jukebox track to the playlist containing it
+    function getPlaylistIds(playlists) {
+        const ids = new Map()
+        for (const p of playlists) {
+            p.i.forEach(id => ids.set(id, p.id))
+        }
+        return ids
+    }
+
     async function getSrc(p) {
         const id = p.get('track_id')
         if(srcMap.has(id)) {
@@ -0,0 +1,94 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"roll20-audio-bouncer/internal/jsonl"
+	"sync"
+	"time"
+)
+
+// Record ID used for payloads that don't carry a valid one
+const UnknownRecord = "_unknown"
+
+// A payload posted to the ingest endpoint, as it was received
+type Entry struct {
+	ReceivedAt time.Time `json:"receivedAt"`
+	// Set if the syncer applied the state
+	Accepted bool `json:"accepted"`
+	// Why the state was rejected
+	Reason string `json:"reason,omitempty"`
+	// Payload, if it is valid JSON
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// Payload as a string otherwise
+	Raw string `json:"raw,omitempty"`
+}
+
+// Build an entry from a raw payload
+func NewEntry(receivedAt time.Time, body []byte, reason error) Entry {
+	entry := Entry{ReceivedAt: receivedAt, Accepted: reason == nil}
+	if reason != nil {
+		entry.Reason = reason.Error()
+	}
+	if json.Valid(body) {
+		entry.Payload = body
+	} else {
+		entry.Raw = string(body)
+	}
+	return entry
+}
+
+// Capture of the payloads received for each record, as one JSON Lines file per record
+type FileCapture struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileCapture(dir string) (*FileCapture, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create capture directory %s : %w", dir, err)
+	}
+	return &FileCapture{dir: dir}, nil
+}
+
+// Append an entry to the capture of a record
+func (fc *FileCapture) Append(id string, entry Entry) error {
+	// Compact the payload, so that an entry always fits on a single line
+	if len(entry.Payload) > 0 {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, entry.Payload); err != nil {
+			return err
+		}
+		entry.Payload = buf.Bytes()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return jsonl.Append(jsonl.Path(fc.dir, id), line, false)
+}
+
+// Read the capture of a record back, in the order it was received
+func (fc *FileCapture) Entries(id string) ([]Entry, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return ReadFile(jsonl.Path(fc.dir, id))
+}
+
+// Read a capture file
+func ReadFile(path string) ([]Entry, error) {
+	var entries []Entry
+	err := jsonl.Read(path, func(line []byte) error {
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
@@ -0,0 +1,52 @@
+package capture
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"roll20-audio-bouncer/internal/jsonl"
+	"testing"
+	"time"
+)
+
+func TestFileCapture_AppendAndRead(t *testing.T) {
+	fc, err := NewFileCapture(t.TempDir())
+	assert.NoError(t, err)
+	refDate := time.Now()
+	assert.NoError(t, fc.Append("record/1", NewEntry(refDate, []byte("{\n  \"rId\": \"record/1\"\n}"), nil)))
+	assert.NoError(t, fc.Append("record/1", NewEntry(refDate, []byte(`{"rId": "record/1"}`), fmt.Errorf("Test"))))
+	assert.NoError(t, fc.Append("record/1", NewEntry(refDate, []byte(`{"rId": `), fmt.Errorf("Test"))))
+
+	entries, err := fc.Entries("record/1")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	assert.True(t, entries[0].Accepted)
+	assert.JSONEq(t, `{"rId": "record/1"}`, string(entries[0].Payload))
+	assert.False(t, entries[1].Accepted)
+	assert.Equal(t, "Test", entries[1].Reason)
+	// Invalid JSON is kept as is
+	assert.Empty(t, entries[2].Payload)
+	assert.Equal(t, `{"rId": `, entries[2].Raw)
+	assert.True(t, refDate.Equal(entries[0].ReceivedAt))
+}
+
+func TestFileCapture_NoCapture(t *testing.T) {
+	fc, err := NewFileCapture(t.TempDir())
+	assert.NoError(t, err)
+	_, err = fc.Entries("1")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestReadFile_CorruptedEntry(t *testing.T) {
+	dir := t.TempDir()
+	fc, err := NewFileCapture(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, fc.Append("1", NewEntry(time.Now(), []byte(`{}`), nil)))
+	f, err := os.OpenFile(jsonl.Path(dir, "1"), os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.NoError(t, err)
+	_, _ = f.WriteString(`{"accep`)
+	assert.NoError(t, f.Close())
+	entries, err := ReadFile(jsonl.Path(dir, "1"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
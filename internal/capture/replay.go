@@ -0,0 +1,101 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	jukebox_syncer "roll20-audio-bouncer/service/jukebox-syncer"
+	"sort"
+	"sync"
+	"time"
+)
+
+// What a replay needs from the syncer
+type StateHandler interface {
+	Start(id string) error
+	Handle(state *jukebox_syncer.R20State) error
+	Stop(id string) (*jukebox_syncer.RecordOutput, error)
+}
+
+// Outcome of a replay
+type ReplayStats struct {
+	// States applied by the syncer
+	Replayed int
+	// Entries without a valid state of the record
+	Skipped int
+	// States the syncer refused
+	Rejected int
+}
+
+// Push a captured session of a record through a syncer, from start to stop.
+// The speed is how many times faster than real time the states are pushed, 0 pushing them as fast as possible.
+// Each state is dated again, so that it is as old when replayed as it was when it was captured
+func Replay(ctx context.Context, id string, entries []Entry, handler StateHandler, speed float64) (*ReplayStats, error) {
+	if speed < 0 {
+		return nil, fmt.Errorf("invalid replay speed %f", speed)
+	}
+	if err := handler.Start(id); err != nil {
+		return nil, fmt.Errorf("could not start record %s : %w", id, err)
+	}
+	stats := &ReplayStats{}
+	// States are captured once the syncer is done with them, which isn't always the order they were received in
+	entries = append([]Entry(nil), entries...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].ReceivedAt.Before(entries[j].ReceivedAt) })
+	var mu sync.Mutex
+	var previous time.Time
+	for _, entry := range entries {
+		var state jukebox_syncer.R20State
+		if len(entry.Payload) == 0 || json.Unmarshal(entry.Payload, &state) != nil || state.Rid != id {
+			stats.Skipped++
+			continue
+		}
+		if speed > 0 && !previous.IsZero() {
+			if err := sleep(ctx, time.Duration(float64(entry.ReceivedAt.Sub(previous))/speed)); err != nil {
+				return stats, err
+			}
+		}
+		previous = entry.ReceivedAt
+
+		now := time.Now()
+		state.Date = now.Add(-entry.ReceivedAt.Sub(state.Date))
+		state.ReceivedAt = now
+		receivedAt := entry.ReceivedAt
+		state.Outcome = func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				slog.Warn(fmt.Sprintf("[Replay] :: state received at %s was rejected : %s", receivedAt, err))
+				stats.Rejected++
+				return
+			}
+			stats.Replayed++
+		}
+		if err := handler.Handle(&state); err != nil {
+			state.Outcome(err)
+		}
+	}
+	// Stopping the record applies the states still buffered, so every outcome is known afterwards
+	_, err := handler.Stop(id)
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil {
+		return stats, fmt.Errorf("could not stop record %s : %w", id, err)
+	}
+	return stats, nil
+}
+
+// Wait for the given duration, unless the context is cancelled first
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
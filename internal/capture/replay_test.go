@@ -0,0 +1,110 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	jukebox_syncer "roll20-audio-bouncer/service/jukebox-syncer"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	refDate := time.Now().Add(-time.Hour)
+	entries := []Entry{
+		stateEntry(t, refDate, &jukebox_syncer.R20State{Rid: "1", Date: refDate.Add(-2 * time.Second)}),
+		// Not a valid state
+		NewEntry(refDate, []byte(`{"rId": `), fmt.Errorf("Test")),
+		// Another record
+		stateEntry(t, refDate, &jukebox_syncer.R20State{Rid: "2", Date: refDate}),
+		stateEntry(t, refDate.Add(50*time.Millisecond), &jukebox_syncer.R20State{Rid: "1", Date: refDate}),
+	}
+	h := &mockHandler{}
+	start := time.Now()
+	stats, err := Replay(context.Background(), "1", entries, h, 1)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Equal(t, &ReplayStats{Replayed: 2, Skipped: 2}, stats)
+	assert.Equal(t, []string{"start 1", "handle 1", "handle 1", "stop 1"}, h.calls)
+	// States are as old as they were when captured
+	assert.InDelta(t, 2*time.Second, h.states[0].ReceivedAt.Sub(h.states[0].Date), float64(10*time.Millisecond))
+	assert.InDelta(t, 50*time.Millisecond, h.states[1].ReceivedAt.Sub(h.states[1].Date), float64(10*time.Millisecond))
+}
+
+// Without delay, a long session is replayed at once
+func TestReplay_NoDelay(t *testing.T) {
+	refDate := time.Now().Add(-time.Hour)
+	entries := []Entry{
+		stateEntry(t, refDate, &jukebox_syncer.R20State{Rid: "1", Date: refDate}),
+		stateEntry(t, refDate.Add(time.Hour), &jukebox_syncer.R20State{Rid: "1", Date: refDate.Add(time.Hour)}),
+	}
+	h := &mockHandler{handleErr: fmt.Errorf("Test")}
+	stats, err := Replay(context.Background(), "1", entries, h, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, &ReplayStats{Rejected: 2}, stats)
+}
+
+// States rejected by the worker of the record are counted too, and states are replayed in the order they were received
+func TestReplay_RejectedLater(t *testing.T) {
+	refDate := time.Now().Add(-time.Hour)
+	entries := []Entry{
+		stateEntry(t, refDate.Add(time.Second), &jukebox_syncer.R20State{Rid: "1", Uid: "b", Date: refDate}),
+		stateEntry(t, refDate, &jukebox_syncer.R20State{Rid: "1", Uid: "a", Date: refDate}),
+	}
+	h := &mockHandler{outcomeErr: fmt.Errorf("Test")}
+	stats, err := Replay(context.Background(), "1", entries, h, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, &ReplayStats{Rejected: 2}, stats)
+	assert.Equal(t, "a", h.states[0].Uid)
+}
+
+func TestReplay_Cancelled(t *testing.T) {
+	refDate := time.Now()
+	entries := []Entry{
+		stateEntry(t, refDate, &jukebox_syncer.R20State{Rid: "1", Date: refDate}),
+		stateEntry(t, refDate.Add(time.Hour), &jukebox_syncer.R20State{Rid: "1", Date: refDate.Add(time.Hour)}),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := Replay(ctx, "1", entries, &mockHandler{}, 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestReplay_InvalidSpeed(t *testing.T) {
+	_, err := Replay(context.Background(), "1", nil, &mockHandler{}, -1)
+	assert.Error(t, err)
+}
+
+func stateEntry(t *testing.T, receivedAt time.Time, state *jukebox_syncer.R20State) Entry {
+	body, err := json.Marshal(state)
+	assert.NoError(t, err)
+	return NewEntry(receivedAt, body, nil)
+}
+
+type mockHandler struct {
+	calls     []string
+	states    []*jukebox_syncer.R20State
+	handleErr error
+	// Outcome of the states handed over
+	outcomeErr error
+}
+
+func (m *mockHandler) Start(id string) error {
+	m.calls = append(m.calls, "start "+id)
+	return nil
+}
+
+func (m *mockHandler) Handle(state *jukebox_syncer.R20State) error {
+	m.calls = append(m.calls, "handle "+state.Rid)
+	m.states = append(m.states, state)
+	if m.handleErr == nil {
+		state.Outcome(m.outcomeErr)
+	}
+	return m.handleErr
+}
+
+func (m *mockHandler) Stop(id string) (*jukebox_syncer.RecordOutput, error) {
+	m.calls = append(m.calls, "stop "+id)
+	return &jukebox_syncer.RecordOutput{RecordId: id}, nil
+}
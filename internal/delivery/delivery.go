@@ -0,0 +1,237 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"log/slog"
+	pb "roll20-audio-bouncer/proto"
+	"sync"
+	"time"
+)
+
+// Returned without calling the mixer while the circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open, the mixer is considered unreachable")
+
+// Mixer API being wrapped
+type Mixer interface {
+	Start(id string) error
+	Stop(id string) (*pb.StopResult, error)
+	Send(evt *pb.Event) error
+}
+
+// Check if a failed call is worth retrying: the mixer couldn't be reached, or is overloaded.
+// Any other error is a rejection of the call, which would fail again
+func IsTransient(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, io.EOF) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// Starting or stopping a record twice isn't harmless, so these calls are only retried
+// when the mixer couldn't receive them
+func notReceived(err error) bool {
+	return status.Code(err) == codes.Unavailable
+}
+
+// An event that couldn't be delivered to the mixer.
+// A single letter is kept per event, see deadLetterKey
+type DeadLetter struct {
+	Event *pb.Event `json:"event"`
+	Error string    `json:"error"`
+	Date  time.Time `json:"date"`
+}
+
+// Delivery layer around the mixer.
+// Transient failures are retried with an exponential backoff, and a circuit breaker stops calling the mixer
+// once it failed too many times in a row. Events that couldn't be delivered are kept in a dead letter list
+type ReliableMixer struct {
+	inner Mixer
+	// Number of retries after the first attempt
+	maxRetries int
+	// Delay before the first retry, doubled after each attempt
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	// Consecutive failures opening the circuit
+	breakerThreshold int
+	// How long the circuit stays open before letting a call through again
+	breakerCooldown time.Duration
+	// Size of the dead letter list, oldest letters are dropped first
+	maxDeadLetters int
+
+	failures    int
+	openedUntil time.Time
+	deadLetters []DeadLetter
+	mu          sync.Mutex
+}
+
+// Optional configuration of the delivery layer
+type Option func(*ReliableMixer)
+
+func WithRetries(maxRetries int, baseDelay, maxDelay time.Duration) Option {
+	return func(rm *ReliableMixer) {
+		rm.maxRetries = maxRetries
+		rm.baseDelay = baseDelay
+		rm.maxDelay = maxDelay
+	}
+}
+
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(rm *ReliableMixer) {
+		rm.breakerThreshold = threshold
+		rm.breakerCooldown = cooldown
+	}
+}
+
+func WithDeadLetterLimit(limit int) Option {
+	return func(rm *ReliableMixer) {
+		rm.maxDeadLetters = limit
+	}
+}
+
+func NewReliableMixer(inner Mixer, opts ...Option) *ReliableMixer {
+	rm := &ReliableMixer{
+		inner:            inner,
+		maxRetries:       3,
+		baseDelay:        100 * time.Millisecond,
+		maxDelay:         2 * time.Second,
+		breakerThreshold: 5,
+		breakerCooldown:  10 * time.Second,
+		maxDeadLetters:   1000,
+	}
+	for _, opt := range opts {
+		opt(rm)
+	}
+	return rm
+}
+
+func (rm *ReliableMixer) Start(id string) error {
+	return rm.call(notReceived, func() error { return rm.inner.Start(id) })
+}
+
+func (rm *ReliableMixer) Stop(id string) (*pb.StopResult, error) {
+	var reply *pb.StopResult
+	err := rm.call(notReceived, func() error {
+		var err error
+		reply, err = rm.inner.Stop(id)
+		return err
+	})
+	return reply, err
+}
+
+func (rm *ReliableMixer) Send(evt *pb.Event) error {
+	err := rm.call(IsTransient, func() error { return rm.inner.Send(evt) })
+	if err != nil {
+		rm.AddDeadLetter(evt, err)
+	} else {
+		// The mixer caught up with an event that failed before
+		rm.removeDeadLetter(evt)
+	}
+	return err
+}
+
+// Retrieve the events that couldn't be delivered for a record, or for all records if id is empty
+func (rm *ReliableMixer) DeadLetters(id string) []DeadLetter {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	letters := make([]DeadLetter, 0)
+	for _, l := range rm.deadLetters {
+		if id == "" || l.Event.RecordId == id {
+			letters = append(letters, l)
+		}
+	}
+	return letters
+}
+
+// Call the mixer, retrying the failures accepted by retryable
+func (rm *ReliableMixer) call(retryable func(error) bool, fn func() error) error {
+	var err error
+	delay := rm.baseDelay
+	for attempt := 0; attempt <= rm.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay = min(2*delay, rm.maxDelay)
+		}
+		if !rm.allow() {
+			// No need to retry, the circuit won't close before the cooldown
+			return ErrCircuitOpen
+		}
+		if err = fn(); err == nil {
+			rm.onSuccess()
+			return nil
+		}
+		if !IsTransient(err) {
+			// The mixer answered, it is reachable
+			rm.onSuccess()
+			return err
+		}
+		rm.onFailure()
+		if !retryable(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("mixer call failed after %d attempts : %w", rm.maxRetries+1, err)
+}
+
+// Check if the circuit breaker lets a call through
+func (rm *ReliableMixer) allow() bool {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return !time.Now().Before(rm.openedUntil)
+}
+
+func (rm *ReliableMixer) onSuccess() {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.failures = 0
+}
+
+func (rm *ReliableMixer) onFailure() {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.failures++
+	// Once open, a single failure after the cooldown is enough to open the circuit again
+	if rm.breakerThreshold > 0 && rm.failures >= rm.breakerThreshold {
+		rm.openedUntil = time.Now().Add(rm.breakerCooldown)
+		slog.Warn(fmt.Sprintf("[Delivery] :: mixer failed %d times in a row, pausing calls for %s", rm.failures, rm.breakerCooldown))
+	}
+}
+
+// A track keeps failing with the same event, sent again with every later delta
+func deadLetterKey(evt *pb.Event) string {
+	return fmt.Sprintf("%s/%s/%s", evt.RecordId, evt.EvtId, evt.Type)
+}
+
+// Keep an event that couldn't be delivered, replacing the previous letter of the same event
+func (rm *ReliableMixer) AddDeadLetter(evt *pb.Event, err error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.deleteDeadLetter(deadLetterKey(evt))
+	rm.deadLetters = append(rm.deadLetters, DeadLetter{Event: evt, Error: err.Error(), Date: time.Now()})
+	if over := len(rm.deadLetters) - rm.maxDeadLetters; over > 0 {
+		rm.deadLetters = rm.deadLetters[over:]
+	}
+}
+
+func (rm *ReliableMixer) removeDeadLetter(evt *pb.Event) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.deleteDeadLetter(deadLetterKey(evt))
+}
+
+// Must be called with the mutex held
+func (rm *ReliableMixer) deleteDeadLetter(key string) {
+	for i, l := range rm.deadLetters {
+		if deadLetterKey(l.Event) == key {
+			rm.deadLetters = append(rm.deadLetters[:i], rm.deadLetters[i+1:]...)
+			return
+		}
+	}
+}
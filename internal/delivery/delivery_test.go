@@ -0,0 +1,147 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pb "roll20-audio-bouncer/proto"
+	"testing"
+	"time"
+)
+
+func TestReliableMixer_Success(t *testing.T) {
+	m := &flakyMixer{}
+	rm := NewReliableMixer(m)
+	assert.NoError(t, rm.Send(&pb.Event{RecordId: "1"}))
+	assert.Equal(t, 1, m.calls)
+	assert.Empty(t, rm.DeadLetters(""))
+}
+
+// A transient error must be retried
+func TestReliableMixer_Retry(t *testing.T) {
+	m := &flakyMixer{failures: 2}
+	rm := NewReliableMixer(m, WithRetries(3, time.Millisecond, time.Millisecond))
+	assert.NoError(t, rm.Send(&pb.Event{RecordId: "1"}))
+	assert.Equal(t, 3, m.calls)
+	assert.Empty(t, rm.DeadLetters(""))
+}
+
+// An event that can't be delivered must end up in the dead letter list
+func TestReliableMixer_DeadLetter(t *testing.T) {
+	m := &flakyMixer{failures: 10}
+	rm := NewReliableMixer(m, WithRetries(2, time.Millisecond, time.Millisecond), WithCircuitBreaker(0, 0))
+	assert.Error(t, rm.Send(&pb.Event{RecordId: "1", EvtId: "a"}))
+	assert.Equal(t, 3, m.calls)
+	assert.Error(t, rm.Send(&pb.Event{RecordId: "2", EvtId: "b"}))
+	assert.Len(t, rm.DeadLetters(""), 2)
+	letters := rm.DeadLetters("1")
+	assert.Len(t, letters, 1)
+	assert.Equal(t, "a", letters[0].Event.EvtId)
+}
+
+// A rejected event is dead lettered right away, without retries nor counting as an outage
+func TestReliableMixer_Rejected(t *testing.T) {
+	m := &flakyMixer{failures: 10, code: codes.InvalidArgument}
+	rm := NewReliableMixer(m, WithRetries(3, time.Millisecond, time.Millisecond), WithCircuitBreaker(1, time.Minute))
+	assert.Error(t, rm.Send(&pb.Event{RecordId: "1", EvtId: "a"}))
+	assert.Equal(t, 1, m.calls)
+	assert.Len(t, rm.DeadLetters("1"), 1)
+	assert.False(t, errors.Is(rm.Send(&pb.Event{RecordId: "1", EvtId: "b"}), ErrCircuitOpen))
+}
+
+// An event failing again and again is only listed once, until it is delivered
+func TestReliableMixer_DeadLetterOncePerEvent(t *testing.T) {
+	m := &flakyMixer{failures: 3}
+	rm := NewReliableMixer(m, WithRetries(0, 0, 0), WithCircuitBreaker(0, 0))
+	for i := 0; i < 3; i++ {
+		assert.Error(t, rm.Send(&pb.Event{RecordId: "1", EvtId: "a", Type: pb.EventType_PLAY, AssetUrl: fmt.Sprint(i)}))
+	}
+	letters := rm.DeadLetters("1")
+	assert.Len(t, letters, 1)
+	assert.Equal(t, "2", letters[0].Event.AssetUrl)
+	assert.NoError(t, rm.Send(&pb.Event{RecordId: "1", EvtId: "a", Type: pb.EventType_PLAY}))
+	assert.Empty(t, rm.DeadLetters("1"))
+}
+
+func TestReliableMixer_DeadLetterLimit(t *testing.T) {
+	m := &flakyMixer{failures: 10}
+	rm := NewReliableMixer(m, WithRetries(0, 0, 0), WithCircuitBreaker(0, 0), WithDeadLetterLimit(2))
+	for i := 0; i < 3; i++ {
+		assert.Error(t, rm.Send(&pb.Event{RecordId: "1", EvtId: fmt.Sprint(i)}))
+	}
+	letters := rm.DeadLetters("1")
+	assert.Len(t, letters, 2)
+	assert.Equal(t, "1", letters[0].Event.EvtId)
+}
+
+// Once open, the circuit breaker must stop calling the mixer until the cooldown is over
+func TestReliableMixer_CircuitBreaker(t *testing.T) {
+	m := &flakyMixer{failures: 3}
+	rm := NewReliableMixer(m, WithRetries(0, 0, 0), WithCircuitBreaker(3, 50*time.Millisecond))
+	for i := 0; i < 3; i++ {
+		assert.Error(t, rm.Send(&pb.Event{RecordId: "1"}))
+	}
+	err := rm.Send(&pb.Event{RecordId: "1"})
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.Equal(t, 3, m.calls)
+	time.Sleep(50 * time.Millisecond)
+	// The mixer is back
+	assert.NoError(t, rm.Send(&pb.Event{RecordId: "1"}))
+	assert.Equal(t, 4, m.calls)
+}
+
+func TestReliableMixer_StartStop(t *testing.T) {
+	m := &flakyMixer{failures: 1}
+	rm := NewReliableMixer(m, WithRetries(1, time.Millisecond, time.Millisecond))
+	assert.NoError(t, rm.Start("1"))
+	reply, err := rm.Stop("1")
+	assert.NoError(t, err)
+	// The reply of the mixer is kept through the retries
+	assert.Equal(t, "records/1", reply.StorageKey)
+	assert.Equal(t, 3, m.calls)
+}
+
+// A start that may have reached the mixer must not be sent twice
+func TestReliableMixer_StartTimeout(t *testing.T) {
+	m := &flakyMixer{failures: 1, code: codes.DeadlineExceeded}
+	rm := NewReliableMixer(m, WithRetries(3, time.Millisecond, time.Millisecond))
+	assert.Error(t, rm.Start("1"))
+	assert.Equal(t, 1, m.calls)
+}
+
+// Mixer failing a given number of times before succeeding
+type flakyMixer struct {
+	failures int
+	// Code of the failures, the mixer is unreachable by default
+	code  codes.Code
+	calls int
+}
+
+func (m *flakyMixer) call() error {
+	m.calls++
+	if m.calls <= m.failures {
+		code := m.code
+		if code == codes.OK {
+			code = codes.Unavailable
+		}
+		return status.Error(code, "Test")
+	}
+	return nil
+}
+
+func (m *flakyMixer) Start(id string) error {
+	return m.call()
+}
+
+func (m *flakyMixer) Stop(id string) (*pb.StopResult, error) {
+	if err := m.call(); err != nil {
+		return nil, err
+	}
+	return &pb.StopResult{StopReply: &pb.StopReply{StorageKey: "records/" + id}}, nil
+}
+
+func (m *flakyMixer) Send(evt *pb.Event) error {
+	return m.call()
+}
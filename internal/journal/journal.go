@@ -0,0 +1,222 @@
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"roll20-audio-bouncer/internal/jsonl"
+	pb "roll20-audio-bouncer/proto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What a journal entry is about
+type Kind string
+
+const (
+	KindStart Kind = "start"
+	KindEvent Kind = "event"
+	KindStop  Kind = "stop"
+)
+
+// Marks the journal of a stopped record
+const closedExt = ".closed"
+
+// A call made to the mixer for a record, and its result
+type Entry struct {
+	Date time.Time `json:"date"`
+	Kind Kind      `json:"kind"`
+	// Event sent to the mixer, only set for the event kind
+	Event *pb.Event `json:"event,omitempty"`
+	// Title of the track the event is about
+	Title string `json:"title,omitempty"`
+	// Delivery error, empty if the mixer accepted the call
+	Error string `json:"error,omitempty"`
+	// Set if the event wasn't sent at all, the reason being the error
+	Skipped bool `json:"skipped,omitempty"`
+	// What the mixer answered once it read all the events of the record, only set for the stop kind
+	Message string `json:"message,omitempty"`
+}
+
+// Encoding of an entry, with the event encoded like everywhere else events are written
+type encodedEntry struct {
+	entry
+	Event json.RawMessage `json:"event,omitempty"`
+}
+
+// Entry without its JSON methods
+type entry Entry
+
+func (e Entry) MarshalJSON() ([]byte, error) {
+	enc := encodedEntry{entry: entry(e)}
+	if e.Event != nil {
+		raw, err := protojson.Marshal(e.Event)
+		if err != nil {
+			return nil, err
+		}
+		enc.Event = raw
+	}
+	return json.Marshal(enc)
+}
+
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	var enc encodedEntry
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return err
+	}
+	*e = Entry(enc.entry)
+	e.Event = nil
+	if len(enc.Event) == 0 || string(enc.Event) == "null" {
+		return nil
+	}
+	e.Event = &pb.Event{}
+	if err := protojson.Unmarshal(enc.Event, e.Event); err != nil {
+		// Journals written before events were encoded with protojson
+		if json.Unmarshal(enc.Event, e.Event) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Journal of the calls made to the mixer, as one JSON Lines file per record.
+// The journal of a stopped record is kept for the retention period, then purged
+type FileJournal struct {
+	dir       string
+	retention time.Duration
+	// How often expired journals are looked for
+	purgeInterval time.Duration
+	mu            sync.Mutex
+}
+
+// Optional configuration of the journal
+type Option func(*FileJournal)
+
+func WithRetention(retention time.Duration) Option {
+	return func(fj *FileJournal) {
+		fj.retention = retention
+	}
+}
+
+func WithPurgeInterval(interval time.Duration) Option {
+	return func(fj *FileJournal) {
+		fj.purgeInterval = interval
+	}
+}
+
+// Create a journal storing its files in dir. Expired journals are purged in the background until ctx is cancelled
+func NewFileJournal(ctx context.Context, dir string, opts ...Option) (*FileJournal, error) {
+	fj := &FileJournal{
+		dir:           dir,
+		retention:     72 * time.Hour,
+		purgeInterval: time.Hour,
+	}
+	for _, opt := range opts {
+		opt(fj)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create journal directory %s : %w", dir, err)
+	}
+	go fj.purgeLoop(ctx)
+	return fj, nil
+}
+
+// Path of the marker of a stopped record
+func (fj *FileJournal) closedPath(id string) string {
+	return filepath.Join(fj.dir, url.PathEscape(id)+closedExt)
+}
+
+// Append an entry to the journal of a record
+func (fj *FileJournal) Append(id string, entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	fj.mu.Lock()
+	defer fj.mu.Unlock()
+	// A record started again must not be purged anymore
+	if entry.Kind == KindStart {
+		if err := os.Remove(fj.closedPath(id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return jsonl.Append(jsonl.Path(fj.dir, id), line, false)
+}
+
+// Read the journal of a record back, nil if there is none
+func (fj *FileJournal) Entries(id string) ([]Entry, error) {
+	fj.mu.Lock()
+	defer fj.mu.Unlock()
+	entries, err := ReadFile(jsonl.Path(fj.dir, id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// Read a journal file
+func ReadFile(path string) ([]Entry, error) {
+	entries := []Entry{}
+	err := jsonl.Read(path, func(line []byte) error {
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// Mark the journal of a stopped record, it will be purged once the retention period expired
+func (fj *FileJournal) Close(id string) error {
+	fj.mu.Lock()
+	defer fj.mu.Unlock()
+	return os.WriteFile(fj.closedPath(id), nil, 0o644)
+}
+
+func (fj *FileJournal) purgeLoop(ctx context.Context) {
+	ticker := time.NewTicker(fj.purgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := fj.purge(now); err != nil {
+				slog.Error(fmt.Sprintf("[Journal] :: while purging expired journals : %s", err))
+			}
+		}
+	}
+}
+
+// Delete the journals of the records stopped for longer than the retention period
+func (fj *FileJournal) purge(now time.Time) error {
+	fj.mu.Lock()
+	defer fj.mu.Unlock()
+	markers, err := filepath.Glob(filepath.Join(fj.dir, "*"+closedExt))
+	if err != nil {
+		return err
+	}
+	for _, marker := range markers {
+		info, err := os.Stat(marker)
+		if err != nil || now.Sub(info.ModTime()) < fj.retention {
+			continue
+		}
+		journal := strings.TrimSuffix(marker, closedExt) + jsonl.FileExt
+		if err := os.Remove(journal); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Remove(marker); err != nil {
+			return err
+		}
+		slog.Info(fmt.Sprintf("[Journal] :: purged expired journal %s", journal))
+	}
+	return nil
+}
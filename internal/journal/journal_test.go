@@ -0,0 +1,102 @@
+package journal
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"os"
+	"roll20-audio-bouncer/internal/jsonl"
+	pb "roll20-audio-bouncer/proto"
+	"testing"
+	"time"
+)
+
+func newTestJournal(t *testing.T, dir string, opts ...Option) *FileJournal {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	// Purges are triggered manually
+	fj, err := NewFileJournal(ctx, dir, append([]Option{WithPurgeInterval(time.Hour)}, opts...)...)
+	assert.NoError(t, err)
+	return fj
+}
+
+func TestFileJournal_NoJournal(t *testing.T) {
+	fj := newTestJournal(t, t.TempDir())
+	entries, err := fj.Entries("1")
+	assert.NoError(t, err)
+	assert.Nil(t, entries)
+}
+
+func TestFileJournal_AppendAndRead(t *testing.T) {
+	fj := newTestJournal(t, t.TempDir())
+	assert.NoError(t, fj.Append("record/1", Entry{Kind: KindStart}))
+	assert.NoError(t, fj.Append("record/1", Entry{Kind: KindEvent, Event: &pb.Event{EvtId: "a", Type: pb.EventType_PLAY}}))
+	assert.NoError(t, fj.Append("record/1", Entry{Kind: KindEvent, Event: &pb.Event{EvtId: "b"}, Error: "unreachable"}))
+	assert.NoError(t, fj.Append("2", Entry{Kind: KindStart}))
+
+	entries, err := fj.Entries("record/1")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, KindStart, entries[0].Kind)
+	assert.Equal(t, "a", entries[1].Event.EvtId)
+	assert.Equal(t, pb.EventType_PLAY, entries[1].Event.Type)
+	assert.Equal(t, "unreachable", entries[2].Error)
+}
+
+// Events are written like everywhere else, enums by name and dates as RFC 3339
+func TestFileJournal_EventEncoding(t *testing.T) {
+	dir := t.TempDir()
+	fj := newTestJournal(t, dir)
+	stateDate := time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+	assert.NoError(t, fj.Append("1", Entry{Kind: KindEvent, Event: &pb.Event{RecordId: "1", Type: pb.EventType_PLAY, StateDate: timestamppb.New(stateDate)}}))
+	content, err := os.ReadFile(jsonl.Path(dir, "1"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `"type":"PLAY"`)
+	assert.Contains(t, string(content), `"stateDate":"2024-01-01T20:00:00Z"`)
+	entries, err := fj.Entries("1")
+	assert.NoError(t, err)
+	assert.Equal(t, pb.EventType_PLAY, entries[0].Event.Type)
+	assert.True(t, stateDate.Equal(entries[0].Event.StateDate.AsTime()))
+}
+
+func TestFileJournal_CorruptedEntry(t *testing.T) {
+	dir := t.TempDir()
+	fj := newTestJournal(t, dir)
+	assert.NoError(t, fj.Append("1", Entry{Kind: KindStart}))
+	f, err := os.OpenFile(jsonl.Path(dir, "1"), os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.NoError(t, err)
+	_, _ = f.WriteString(`{"kind":"ev`)
+	assert.NoError(t, f.Close())
+	entries, err := fj.Entries("1")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+// Journals of stopped records are kept for the retention period only
+func TestFileJournal_Retention(t *testing.T) {
+	fj := newTestJournal(t, t.TempDir(), WithRetention(time.Minute))
+	assert.NoError(t, fj.Append("stopped", Entry{Kind: KindStart}))
+	assert.NoError(t, fj.Close("stopped"))
+	assert.NoError(t, fj.Append("running", Entry{Kind: KindStart}))
+
+	assert.NoError(t, fj.purge(time.Now()))
+	entries, _ := fj.Entries("stopped")
+	assert.Len(t, entries, 1)
+
+	assert.NoError(t, fj.purge(time.Now().Add(2*time.Minute)))
+	entries, _ = fj.Entries("stopped")
+	assert.Nil(t, entries)
+	entries, _ = fj.Entries("running")
+	assert.Len(t, entries, 1)
+}
+
+// A record started again must keep its journal
+func TestFileJournal_Restarted(t *testing.T) {
+	fj := newTestJournal(t, t.TempDir(), WithRetention(time.Minute))
+	assert.NoError(t, fj.Append("1", Entry{Kind: KindStart}))
+	assert.NoError(t, fj.Close("1"))
+	assert.NoError(t, fj.Append("1", Entry{Kind: KindStart}))
+	assert.NoError(t, fj.purge(time.Now().Add(2*time.Minute)))
+	entries, _ := fj.Entries("1")
+	assert.Len(t, entries, 2)
+}
@@ -0,0 +1,69 @@
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	pb "roll20-audio-bouncer/proto"
+)
+
+// The journal has no successful start
+var ErrNothingToReplay = errors.New("the journal has no recording to replay")
+
+// Mixer the events of a journal are replayed to
+type Mixer interface {
+	Start(id string) error
+	Send(evt *pb.Event) error
+	Stop(id string) (*pb.StopResult, error)
+}
+
+// Outcome of a replay
+type ReplayResult struct {
+	// Record the events were replayed into
+	RecordId string `json:"recordId"`
+	Events   int    `json:"events"`
+	// Events the mixer didn't accept this time
+	Failed     int    `json:"failed"`
+	StorageKey string `json:"storageKey"`
+}
+
+// Send the events the mixer accepted during the last recording of a journal again, into a new record.
+// Events are sent in their original order, without waiting between them: the mixer places them using their state date.
+// Comparing both recordings tells whether an issue comes from the events sent, or from the mixer
+func Replay(entries []Entry, target string, mixer Mixer) (*ReplayResult, error) {
+	// A record may have been recorded multiple times, only the last recording is relevant.
+	// Starts are only journaled when a recording begins
+	first := -1
+	for i, e := range entries {
+		if e.Kind == KindStart && e.Error == "" {
+			first = i
+		}
+	}
+	if first < 0 {
+		return nil, ErrNothingToReplay
+	}
+	if err := mixer.Start(target); err != nil {
+		return nil, fmt.Errorf("could not start record %s : %w", target, err)
+	}
+	result := &ReplayResult{RecordId: target}
+	for _, e := range entries[first+1:] {
+		if e.Kind == KindStop && e.Error == "" {
+			break
+		}
+		if e.Kind != KindEvent || e.Event == nil || e.Error != "" {
+			continue
+		}
+		evt := proto.Clone(e.Event).(*pb.Event)
+		evt.RecordId = target
+		result.Events++
+		if err := mixer.Send(evt); err != nil {
+			result.Failed++
+		}
+	}
+	reply, err := mixer.Stop(target)
+	if err != nil {
+		return result, fmt.Errorf("could not stop record %s : %w", target, err)
+	}
+	result.StorageKey = reply.GetStorageKey()
+	return result, nil
+}
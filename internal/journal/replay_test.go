@@ -0,0 +1,55 @@
+package journal
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	pb "roll20-audio-bouncer/proto"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	entries := []Entry{
+		{Kind: KindStart},
+		{Kind: KindEvent, Event: &pb.Event{RecordId: "1", EvtId: "old"}},
+		{Kind: KindStop},
+		{Kind: KindStart},
+		{Kind: KindEvent, Event: &pb.Event{RecordId: "1", EvtId: "a", Type: pb.EventType_PLAY}},
+		{Kind: KindEvent, Event: &pb.Event{RecordId: "1", EvtId: "b"}, Error: "unreachable"},
+		{Kind: KindEvent, Event: &pb.Event{RecordId: "1", EvtId: "c"}, Error: "skipped", Skipped: true},
+		{Kind: KindEvent, Event: &pb.Event{RecordId: "1", EvtId: "a", Type: pb.EventType_STOP}},
+		{Kind: KindStop},
+	}
+	m := &replayMixer{}
+	result, err := Replay(entries, "1-replay", m)
+	assert.NoError(t, err)
+	assert.Equal(t, &ReplayResult{RecordId: "1-replay", Events: 2, StorageKey: "records/1-replay"}, result)
+	assert.Equal(t, []string{"start 1-replay", "send 1-replay a PLAY", "send 1-replay a STOP", "stop 1-replay"}, m.calls)
+	// The journal is left untouched
+	assert.Equal(t, "1", entries[4].Event.RecordId)
+}
+
+func TestReplay_NeverStarted(t *testing.T) {
+	m := &replayMixer{}
+	_, err := Replay([]Entry{{Kind: KindStart, Error: "unreachable"}}, "1-replay", m)
+	assert.ErrorIs(t, err, ErrNothingToReplay)
+	assert.Empty(t, m.calls)
+}
+
+type replayMixer struct {
+	calls []string
+}
+
+func (m *replayMixer) Start(id string) error {
+	m.calls = append(m.calls, "start "+id)
+	return nil
+}
+
+func (m *replayMixer) Send(evt *pb.Event) error {
+	m.calls = append(m.calls, fmt.Sprintf("send %s %s %s", evt.RecordId, evt.EvtId, evt.Type))
+	return nil
+}
+
+func (m *replayMixer) Stop(id string) (*pb.StopResult, error) {
+	m.calls = append(m.calls, "stop "+id)
+	return &pb.StopResult{StopReply: &pb.StopReply{StorageKey: "records/" + id}}, nil
+}
@@ -0,0 +1,84 @@
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extension of the JSON Lines files
+const FileExt = ".jsonl"
+
+// Longest line that can be read back
+const maxLineSize = 16 * 1024 * 1024
+
+// Path of the file of a record in dir
+func Path(dir, id string) string {
+	// IDs may contain characters that aren't allowed in a file name
+	return filepath.Join(dir, url.PathEscape(id)+FileExt)
+}
+
+// ID of the record a file belongs to, the reverse of Path
+func RecordId(path string) (string, error) {
+	return url.PathUnescape(strings.TrimSuffix(filepath.Base(path), FileExt))
+}
+
+// Append a line to a file, creating it if needed.
+// If sync is set, the line is flushed to disk before returning
+func Append(path string, line []byte, sync bool) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err == nil && sync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Replace the content of a file with the given lines, at once. The file is removed if there are none
+func Rewrite(path string, lines [][]byte) error {
+	if len(lines) == 0 {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Read a file line by line. A line that decode fails on is skipped
+func Read(path string, decode func(line []byte) error) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		if err := decode(scanner.Bytes()); err != nil {
+			// The process may have crashed while writing the last line
+			slog.Warn(fmt.Sprintf("[JSONL] :: skipping corrupted line in %s : %s", path, err))
+		}
+	}
+	return scanner.Err()
+}
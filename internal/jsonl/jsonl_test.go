@@ -0,0 +1,64 @@
+package jsonl
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func readAll(t *testing.T, path string) []string {
+	var lines []string
+	assert.NoError(t, Read(path, func(line []byte) error {
+		lines = append(lines, string(line))
+		return nil
+	}))
+	return lines
+}
+
+func TestPath_RecordId(t *testing.T) {
+	path := Path(t.TempDir(), "a/b c")
+	id, err := RecordId(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "a/b c", id)
+}
+
+func TestAppend(t *testing.T) {
+	path := Path(t.TempDir(), "1")
+	assert.NoError(t, Append(path, []byte(`{"a":1}`), false))
+	assert.NoError(t, Append(path, []byte(`{"a":2}`), true))
+	assert.Equal(t, []string{`{"a":1}`, `{"a":2}`}, readAll(t, path))
+}
+
+func TestRewrite(t *testing.T) {
+	path := Path(t.TempDir(), "1")
+	assert.NoError(t, Append(path, []byte(`{"a":1}`), false))
+	assert.NoError(t, Rewrite(path, [][]byte{[]byte(`{"a":2}`), []byte(`{"a":3}`)}))
+	assert.Equal(t, []string{`{"a":2}`, `{"a":3}`}, readAll(t, path))
+	// Without any line left, the file is removed
+	assert.NoError(t, Rewrite(path, nil))
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, Rewrite(path, nil))
+}
+
+// A line that can't be decoded is skipped, the following ones are still read
+func TestRead_SkipsCorruptedLines(t *testing.T) {
+	path := Path(t.TempDir(), "1")
+	assert.NoError(t, os.WriteFile(path, []byte("{\"a\":1}\n{\"a\n{\"a\":3}\n"), 0o644))
+	var lines []string
+	err := Read(path, func(line []byte) error {
+		if line[len(line)-1] != '}' {
+			return fmt.Errorf("truncated line")
+		}
+		lines = append(lines, string(line))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"a":1}`, `{"a":3}`}, lines)
+}
+
+func TestRead_MissingFile(t *testing.T) {
+	err := Read(Path(t.TempDir(), "1"), func(line []byte) error { return nil })
+	assert.True(t, os.IsNotExist(err))
+}
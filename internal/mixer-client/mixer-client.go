@@ -2,30 +2,147 @@ package mixer_client
 
 import (
 	"context"
+	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
-	"log"
+	"google.golang.org/grpc/status"
+	"log/slog"
 	pb "roll20-audio-bouncer/proto"
+	"sort"
+	"sync"
 	"time"
 )
 
+// Default interval of the keepalive pings. gRPC servers reject pings sent more often than every 5 minutes
+// unless they are configured otherwise, and close the connection of a client sending them
+const DefaultKeepalive = 5 * time.Minute
+
 type MixerClient struct {
-	client  pb.EventStreamClient
-	ctx     context.Context
-	streams map[string]pb.EventStream_StreamEventsClient
+	conn   grpc.ClientConnInterface
+	client pb.EventStreamClient
+	ctx    context.Context
+	// Event stream of each record
+	streams map[string]*recordStream
+	// Records stopped since they were last started, their stream must not be opened again
+	stopped map[string]bool
+	// How often the connection is checked while idle, and how long to wait for the mixer to answer
+	keepaliveTime    time.Duration
+	keepaliveTimeout time.Duration
+	// Delays between two attempts to re-open a broken stream
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	// Called whenever a new stream is opened for a record
+	onStreamOpen func(id string)
+	mu           sync.Mutex
 }
 
-func NewMixerClient(ctx context.Context, address, daprMixerAppId string) (*MixerClient, error) {
-	dialCtx, _ := context.WithTimeout(context.Background(), 5*time.Second)
-	conn, err := grpc.DialContext(dialCtx, address, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+// Optional configuration of the client
+type Option func(*MixerClient)
+
+// Ping the mixer every interval while the connection is idle, considering it broken
+// if the mixer doesn't answer within the timeout. Pings are only sent while a stream is open,
+// and the mixer must allow the interval, see DefaultKeepalive
+func WithKeepalive(interval, timeout time.Duration) Option {
+	return func(mc *MixerClient) {
+		mc.keepaliveTime = interval
+		mc.keepaliveTimeout = timeout
+	}
+}
+
+// Re-open a broken stream after min, doubling the delay after each failure up to max
+func WithReopenBackoff(min, max time.Duration) Option {
+	return func(mc *MixerClient) {
+		mc.minBackoff = min
+		mc.maxBackoff = max
+	}
+}
+
+func NewMixerClient(ctx context.Context, address, daprMixerAppId string, opts ...Option) (*MixerClient, error) {
+	mc := newMixerClient(opts...)
+	dialCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(dialCtx, address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: mc.keepaliveTime, Timeout: mc.keepaliveTimeout, PermitWithoutStream: false}),
+	)
 	if err != nil {
-		log.Fatalf("did not connect: %v", err)
+		return nil, fmt.Errorf("could not connect to the mixer at %s : %w", address, err)
+	}
+	mc.connect(ctx, conn, daprMixerAppId)
+	return mc, nil
+}
+
+func newMixerClient(opts ...Option) *MixerClient {
+	mc := &MixerClient{
+		streams:          map[string]*recordStream{},
+		stopped:          map[string]bool{},
+		keepaliveTime:    DefaultKeepalive,
+		keepaliveTimeout: 10 * time.Second,
+		minBackoff:       500 * time.Millisecond,
+		maxBackoff:       30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(mc)
 	}
+	return mc
+}
+
+// Use an established connection to the mixer, through Dapr
+func (mc *MixerClient) connect(ctx context.Context, conn grpc.ClientConnInterface, daprMixerAppId string) {
 	methodCtx := metadata.AppendToOutgoingContext(ctx, "dapr-app-id", daprMixerAppId)
 	methodCtx = metadata.AppendToOutgoingContext(methodCtx, "dapr-stream", "true")
-	client := pb.NewEventStreamClient(conn)
-	return &MixerClient{client: client, ctx: methodCtx, streams: map[string]pb.EventStream_StreamEventsClient{}}, nil
+	mc.conn = conn
+	mc.client = pb.NewEventStreamClient(conn)
+	mc.ctx = methodCtx
+}
+
+// Check if the client was closed, either its context or its connection.
+// Streams can't be opened anymore then
+func (mc *MixerClient) shutDown() bool {
+	if mc.ctx.Err() != nil {
+		return true
+	}
+	cc, ok := mc.conn.(*grpc.ClientConn)
+	return ok && cc.GetState() == connectivity.Shutdown
+}
+
+// Register a function called whenever a new stream is opened for a record.
+// The mixer doesn't know what happened before on this record then, and may need a full state.
+// The function is called in its own goroutine, so it can use the client
+func (mc *MixerClient) OnStreamOpen(hook func(id string)) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.onStreamOpen = hook
+}
+
+func (mc *MixerClient) streamOpened(id string) {
+	mc.mu.Lock()
+	hook := mc.onStreamOpen
+	mc.mu.Unlock()
+	if hook != nil {
+		go hook(id)
+	}
+}
+
+// Retrieve the stream of a record, creating an empty one if needed.
+// Returns false if the record stopped
+func (mc *MixerClient) record(id string) (*recordStream, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.stopped[id] {
+		return nil, false
+	}
+	rs, ok := mc.streams[id]
+	if !ok {
+		rs = &recordStream{id: id, client: mc}
+		mc.streams[id] = rs
+	}
+	return rs, true
 }
 
 func (mc *MixerClient) Start(id string) error {
@@ -34,46 +151,87 @@ func (mc *MixerClient) Start(id string) error {
 		return err
 	}
 
-	// Create a new stream for this record
-	_, ok := mc.streams[id]
+	// Create a new stream for this record. A broken stream is already being re-opened
+	mc.mu.Lock()
+	delete(mc.stopped, id)
+	mc.mu.Unlock()
+	rs, ok := mc.record(id)
 	if !ok {
-		stream, err := mc.client.StreamEvents(mc.ctx)
-		if err != nil {
-			return err
-		}
-		mc.streams[id] = stream
+		return status.Errorf(codes.Aborted, "record %s was stopped while starting", id)
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.stream == nil && rs.reopenTimer == nil {
+		return rs.open()
 	}
 	return nil
 }
 
-func (mc *MixerClient) Stop(id string) error {
-	_, err := mc.client.Stop(mc.ctx, &pb.StopRequest{Id: id})
-	if err != nil {
-		return err
-	}
-
-	// Close this record stream
-	stream, ok := mc.streams[id]
+// Stop a record, returning what the mixer produced
+func (mc *MixerClient) Stop(id string) (*pb.StopResult, error) {
+	// Close this record stream first, so that the mixer has all the events before stopping.
+	// The record is marked as stopped, so that a late event doesn't open a new stream
+	mc.mu.Lock()
+	rs, ok := mc.streams[id]
+	delete(mc.streams, id)
+	mc.stopped[id] = true
+	mc.mu.Unlock()
+	var streamReply *pb.EventReply
 	if ok {
-		err = stream.CloseSend()
+		var err error
+		streamReply, err = rs.close()
 		if err != nil {
-			return err
+			slog.Warn(fmt.Sprintf("[Mixer client] :: while closing stream of record %s : %s", id, err))
+		} else if streamReply != nil {
+			slog.Info(fmt.Sprintf("[Mixer client] :: closed stream of record %s : %s", id, streamReply.Message))
 		}
-		delete(mc.streams, id)
 	}
-	return nil
+	reply, err := mc.client.Stop(mc.ctx, &pb.StopRequest{Id: id})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.StopResult{StopReply: reply, StreamMessage: streamReply.GetMessage()}, nil
 }
 
 func (mc *MixerClient) Send(evt *pb.Event) error {
-	var err error
-	stream, ok := mc.streams[evt.RecordId]
+	rs, ok := mc.record(evt.RecordId)
 	if !ok {
-		stream, err = mc.client.StreamEvents(mc.ctx)
-		if err != nil {
+		return status.Errorf(codes.FailedPrecondition, "record %s is stopped", evt.RecordId)
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	// The record stopped since the stream was retrieved
+	if rs.closed {
+		return status.Errorf(codes.FailedPrecondition, "record %s is stopped", evt.RecordId)
+	}
+	if rs.stream == nil {
+		if wait := time.Until(rs.nextAttempt); wait > 0 {
+			return status.Errorf(codes.Unavailable, "stream of record %s is broken, re-opening it in %s : %s", rs.id, wait.Round(time.Millisecond), rs.lastError)
+		}
+		if err := rs.open(); err != nil {
 			return err
 		}
-		mc.streams[evt.RecordId] = stream
 	}
-	err = stream.Send(evt)
-	return err
+	if err := rs.stream.Send(evt); err != nil {
+		rs.broken(err)
+		return err
+	}
+	rs.sent()
+	return nil
+}
+
+// Health of the stream of each record, sorted by record
+func (mc *MixerClient) StreamHealth() []StreamHealth {
+	mc.mu.Lock()
+	streams := make([]*recordStream, 0, len(mc.streams))
+	for _, rs := range mc.streams {
+		streams = append(streams, rs)
+	}
+	mc.mu.Unlock()
+	health := make([]StreamHealth, 0, len(streams))
+	for _, rs := range streams {
+		health = append(health, rs.health())
+	}
+	sort.Slice(health, func(i, j int) bool { return health[i].RecordId < health[j].RecordId })
+	return health
 }
@@ -0,0 +1,183 @@
+package mixer_client
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	pb "roll20-audio-bouncer/proto"
+	"sync"
+	"testing"
+	"time"
+)
+
+// In-memory mixer
+type fakeMixer struct {
+	pb.UnimplementedEventStreamServer
+	// Events received, on any stream
+	events []*pb.Event
+	// Break the stream once this many events have been received
+	failAfter int
+	mu        sync.Mutex
+}
+
+func (m *fakeMixer) Start(ctx context.Context, req *pb.RecordRequest) (*pb.RecordReply, error) {
+	return &pb.RecordReply{}, nil
+}
+
+func (m *fakeMixer) Stop(ctx context.Context, req *pb.StopRequest) (*pb.StopReply, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &pb.StopReply{StorageKey: "records/" + req.Id, SizeBytes: int64(len(m.events))}, nil
+}
+
+func (m *fakeMixer) StreamEvents(stream pb.EventStream_StreamEventsServer) error {
+	for {
+		evt, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&pb.EventReply{Message: "Test"})
+		}
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		m.events = append(m.events, evt)
+		fail := len(m.events) == m.failAfter
+		m.mu.Unlock()
+		if fail {
+			return status.Error(codes.Internal, "Test")
+		}
+	}
+}
+
+func (m *fakeMixer) received() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.events)
+}
+
+// Client connected to an in-memory mixer
+func newTestClient(t *testing.T, mixer *fakeMixer) *MixerClient {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	pb.RegisterEventStreamServer(srv, mixer)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	mc := newMixerClient(WithReopenBackoff(10*time.Millisecond, 50*time.Millisecond))
+	mc.connect(context.Background(), conn, "mixer")
+	return mc
+}
+
+func TestNewMixerClient_Unreachable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := NewMixerClient(ctx, "localhost:1", "mixer")
+	assert.Error(t, err)
+}
+
+func TestMixerClient_Send(t *testing.T) {
+	mixer := &fakeMixer{}
+	mc := newTestClient(t, mixer)
+	opened := make(chan string, 10)
+	mc.OnStreamOpen(func(id string) { opened <- id })
+	assert.NoError(t, mc.Start("1"))
+	assert.NoError(t, mc.Send(&pb.Event{RecordId: "1"}))
+	assert.NoError(t, mc.Send(&pb.Event{RecordId: "1"}))
+	// A single stream was opened
+	assert.Equal(t, "1", <-opened)
+	assert.Len(t, opened, 0)
+	health := mc.StreamHealth()
+	assert.Len(t, health, 1)
+	assert.True(t, health[0].Connected)
+	assert.Equal(t, 2, health[0].EventsSent)
+	assert.NotNil(t, health[0].LastSendAt)
+
+	// The stream is closed before stopping, so the mixer read all the events by then
+	reply, err := mc.Stop("1")
+	assert.NoError(t, err)
+	assert.Equal(t, "records/1", reply.StorageKey)
+	assert.Equal(t, int64(2), reply.SizeBytes)
+	assert.Equal(t, "Test", reply.StreamMessage)
+	assert.Empty(t, mc.StreamHealth())
+}
+
+// A broken stream is detected on its own, and opened again
+func TestMixerClient_Reopen(t *testing.T) {
+	mixer := &fakeMixer{failAfter: 1}
+	mc := newTestClient(t, mixer)
+	opened := make(chan string, 10)
+	mc.OnStreamOpen(func(id string) { opened <- id })
+	assert.NoError(t, mc.Send(&pb.Event{RecordId: "1"}))
+	<-opened
+	select {
+	case <-opened:
+	case <-time.After(time.Second):
+		t.Fatal("the stream wasn't opened again")
+	}
+	health := mc.StreamHealth()[0]
+	assert.Equal(t, 2, health.Opens)
+	assert.Equal(t, 1, health.Failures)
+	assert.Contains(t, health.LastError, "Test")
+	assert.NoError(t, mc.Send(&pb.Event{RecordId: "1"}))
+	assert.Eventually(t, func() bool { return mixer.received() == 2 }, time.Second, 10*time.Millisecond)
+}
+
+// Broken streams aren't re-opened once the connection is closed
+func TestMixerClient_ClosedConnection(t *testing.T) {
+	mixer := &fakeMixer{}
+	mc := newTestClient(t, mixer)
+	assert.NoError(t, mc.Send(&pb.Event{RecordId: "1"}))
+	assert.NoError(t, mc.conn.(*grpc.ClientConn).Close())
+	assert.Eventually(t, func() bool { return !mc.StreamHealth()[0].Connected }, time.Second, 10*time.Millisecond)
+	assert.Error(t, mc.Send(&pb.Event{RecordId: "1"}))
+	health := mc.StreamHealth()[0]
+	assert.Nil(t, health.NextAttempt)
+	assert.Equal(t, 1, health.Opens)
+}
+
+// Streams can be used from multiple goroutines
+func TestMixerClient_ConcurrentSend(t *testing.T) {
+	mixer := &fakeMixer{}
+	mc := newTestClient(t, mixer)
+	var wg sync.WaitGroup
+	for _, id := range []string{"1", "2", "3"} {
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				assert.NoError(t, mc.Send(&pb.Event{RecordId: id}))
+			}(id)
+		}
+	}
+	wg.Wait()
+	assert.Eventually(t, func() bool { return mixer.received() == 30 }, time.Second, 10*time.Millisecond)
+	assert.Len(t, mc.StreamHealth(), 3)
+}
+
+// A late event doesn't open a new stream for a stopped record, until the record starts again
+func TestMixerClient_SendAfterStop(t *testing.T) {
+	mixer := &fakeMixer{}
+	mc := newTestClient(t, mixer)
+	assert.NoError(t, mc.Start("1"))
+	_, err := mc.Stop("1")
+	assert.NoError(t, err)
+	err = mc.Send(&pb.Event{RecordId: "1"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Empty(t, mc.StreamHealth())
+	assert.Equal(t, 0, mixer.received())
+
+	assert.NoError(t, mc.Start("1"))
+	assert.NoError(t, mc.Send(&pb.Event{RecordId: "1"}))
+	assert.Eventually(t, func() bool { return mixer.received() == 1 }, time.Second, 10*time.Millisecond)
+}
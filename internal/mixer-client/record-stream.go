@@ -0,0 +1,200 @@
+package mixer_client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	pb "roll20-audio-bouncer/proto"
+	"sync"
+	"time"
+)
+
+// How long the mixer is given to answer a closed stream, before its resources are released
+const closeGracePeriod = 30 * time.Second
+
+// Health of the event stream of a record
+type StreamHealth struct {
+	RecordId string `json:"recordId"`
+	// Set if events can be sent right now
+	Connected bool `json:"connected"`
+	// Number of times the stream was opened, and broke or couldn't be opened
+	Opens    int `json:"opens"`
+	Failures int `json:"failures"`
+	// Number of events sent on the stream
+	EventsSent int        `json:"eventsSent"`
+	LastSendAt *time.Time `json:"lastSendAt,omitempty"`
+	// Last failure of the stream
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+	// When the broken stream will be opened again
+	NextAttempt *time.Time `json:"nextAttempt,omitempty"`
+}
+
+// Answer of the mixer once it read all the events of a stream
+type streamReply struct {
+	reply *pb.EventReply
+	err   error
+}
+
+// The event stream of a single record. A broken stream is re-opened in the background, with an exponential backoff.
+// All the fields are guarded by the mutex, which also serializes the events sent on the stream
+type recordStream struct {
+	id     string
+	client *MixerClient
+	// Current stream, nil while broken
+	stream pb.EventStream_StreamEventsClient
+	// Releases the current stream
+	cancel context.CancelFunc
+	// Answer of the mixer on the current stream
+	reply chan streamReply
+	// Delay before the next attempt to open the stream, and when it is due
+	backoff     time.Duration
+	nextAttempt time.Time
+	reopenTimer *time.Timer
+	// Set once the record stopped, the stream must not be opened again
+	closed bool
+
+	opens, failures, eventsSent int
+	lastError                   string
+	lastSendAt, lastErrorAt     time.Time
+	mu                          sync.Mutex
+}
+
+// Open a new stream for the record. Must be called with the mutex held
+func (rs *recordStream) open() error {
+	ctx, cancel := context.WithCancel(rs.client.ctx)
+	stream, err := rs.client.client.StreamEvents(ctx)
+	if err != nil {
+		cancel()
+		rs.broken(err)
+		return err
+	}
+	rs.stream, rs.cancel, rs.reply = stream, cancel, make(chan streamReply, 1)
+	rs.opens++
+	rs.nextAttempt = time.Time{}
+	go rs.watch(stream, cancel, rs.reply)
+	rs.client.streamOpened(rs.id)
+	return nil
+}
+
+// Wait for the answer of the mixer on a stream. The mixer only answers once the stream is closed,
+// so any answer or error before that means the stream broke
+func (rs *recordStream) watch(stream pb.EventStream_StreamEventsClient, cancel context.CancelFunc, replies chan<- streamReply) {
+	reply := &pb.EventReply{}
+	err := stream.RecvMsg(reply)
+	cancel()
+	replies <- streamReply{reply: reply, err: err}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	// The stream was closed or replaced in the meantime
+	if rs.stream != stream {
+		return
+	}
+	if err == nil {
+		err = fmt.Errorf("the mixer ended the stream")
+	}
+	rs.broken(err)
+}
+
+// Record a failure of the stream, and schedule its re-opening. Must be called with the mutex held
+func (rs *recordStream) broken(err error) {
+	rs.failures++
+	rs.lastError = err.Error()
+	rs.lastErrorAt = time.Now()
+	if rs.stream != nil {
+		rs.cancel()
+		rs.stream, rs.cancel, rs.reply = nil, nil, nil
+	}
+	if rs.closed {
+		return
+	}
+	if rs.client.shutDown() {
+		slog.Warn(fmt.Sprintf("[Mixer client] :: stream of record %s is broken, the client is closed : %s", rs.id, err))
+		return
+	}
+	if rs.backoff == 0 {
+		rs.backoff = rs.client.minBackoff
+	} else {
+		rs.backoff = min(2*rs.backoff, rs.client.maxBackoff)
+	}
+	rs.nextAttempt = time.Now().Add(rs.backoff)
+	slog.Warn(fmt.Sprintf("[Mixer client] :: stream of record %s is broken, re-opening it in %s : %s", rs.id, rs.backoff, err))
+	if rs.reopenTimer != nil {
+		rs.reopenTimer.Stop()
+	}
+	rs.reopenTimer = time.AfterFunc(rs.backoff, rs.reopen)
+}
+
+func (rs *recordStream) reopen() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.reopenTimer = nil
+	// An event may have re-opened the stream already
+	if rs.closed || rs.stream != nil || rs.client.shutDown() {
+		return
+	}
+	if err := rs.open(); err == nil {
+		slog.Info(fmt.Sprintf("[Mixer client] :: re-opened stream of record %s", rs.id))
+	}
+}
+
+// Record an event delivered on the stream. Must be called with the mutex held
+func (rs *recordStream) sent() {
+	rs.eventsSent++
+	rs.lastSendAt = time.Now()
+	// The stream works again, the next failure is handled as a first one
+	rs.backoff = 0
+}
+
+// Close the stream for good, and wait for the mixer to answer once it read all the events.
+// Returns a nil reply if there was no stream to close
+func (rs *recordStream) close() (*pb.EventReply, error) {
+	rs.mu.Lock()
+	rs.closed = true
+	if rs.reopenTimer != nil {
+		rs.reopenTimer.Stop()
+		rs.reopenTimer = nil
+	}
+	stream, cancel, replies := rs.stream, rs.cancel, rs.reply
+	rs.stream, rs.cancel, rs.reply = nil, nil, nil
+	rs.mu.Unlock()
+	if stream == nil {
+		return nil, nil
+	}
+	// The answer is received by the watcher of the stream, the equivalent of CloseAndRecv
+	if err := stream.CloseSend(); err != nil {
+		cancel()
+		return nil, err
+	}
+	select {
+	case r := <-replies:
+		return r.reply, r.err
+	case <-time.After(closeGracePeriod):
+		cancel()
+		return nil, fmt.Errorf("the mixer didn't answer within %s", closeGracePeriod)
+	}
+}
+
+func (rs *recordStream) health() StreamHealth {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return StreamHealth{
+		RecordId:    rs.id,
+		Connected:   rs.stream != nil,
+		Opens:       rs.opens,
+		Failures:    rs.failures,
+		EventsSent:  rs.eventsSent,
+		LastError:   rs.lastError,
+		LastSendAt:  optionalTime(rs.lastSendAt),
+		LastErrorAt: optionalTime(rs.lastErrorAt),
+		NextAttempt: optionalTime(rs.nextAttempt),
+	}
+}
+
+// Dates that never happened are left out of the health report
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,316 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"roll20-audio-bouncer/internal/delivery"
+	"roll20-audio-bouncer/internal/jsonl"
+	pb "roll20-audio-bouncer/proto"
+	"sync"
+	"time"
+)
+
+// What to do with a new event when the outbox of a record is full
+type DropPolicy string
+
+const (
+	// Drop the oldest pending event to make room for the new one
+	DropOldest DropPolicy = "drop-oldest"
+	// Reject the new event
+	Reject DropPolicy = "reject"
+)
+
+// Mixer API being wrapped
+type Mixer interface {
+	Start(id string) error
+	Stop(id string) (*pb.StopResult, error)
+	Send(evt *pb.Event) error
+}
+
+// Where the events the outbox gives up on are reported
+type DeadLetterSink interface {
+	AddDeadLetter(evt *pb.Event, err error)
+}
+
+// Events of a record waiting to be delivered, mirrored in a file
+type recordQueue struct {
+	path    string
+	pending []*pb.Event
+	mu      sync.Mutex
+}
+
+// Durable outbox in front of the mixer, usually wrapping the delivery layer.
+// Each event is written to a per-record file on disk before being sent, and removed once delivered.
+// When the mixer is unreachable, events are kept and sent in order once it comes back,
+// even if the process crashed in the meantime.
+// Events the mixer rejects, and events dropped when the outbox is full, are reported as dead letters
+type Outbox struct {
+	inner Mixer
+	dir   string
+	// Maximum number of pending events per record
+	maxEvents int
+	policy    DropPolicy
+	// How often pending events are retried
+	retryInterval time.Duration
+	// Optional
+	deadLetters DeadLetterSink
+
+	queues map[string]*recordQueue
+	mu     sync.Mutex
+}
+
+// Optional configuration of the outbox
+type Option func(*Outbox)
+
+func WithLimit(maxEvents int, policy DropPolicy) Option {
+	return func(o *Outbox) {
+		o.maxEvents = maxEvents
+		o.policy = policy
+	}
+}
+
+func WithRetryInterval(interval time.Duration) Option {
+	return func(o *Outbox) {
+		o.retryInterval = interval
+	}
+}
+
+func WithDeadLetters(sink DeadLetterSink) Option {
+	return func(o *Outbox) {
+		o.deadLetters = sink
+	}
+}
+
+// Create an outbox storing its files in dir. Events left by a previous process are loaded back,
+// and delivered in the background until ctx is cancelled
+func NewOutbox(ctx context.Context, inner Mixer, dir string, opts ...Option) (*Outbox, error) {
+	o := &Outbox{
+		inner:         inner,
+		dir:           dir,
+		maxEvents:     10000,
+		policy:        DropOldest,
+		retryInterval: time.Second,
+		queues:        map[string]*recordQueue{},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.policy != DropOldest && o.policy != Reject {
+		return nil, fmt.Errorf("unknown drop policy %s", o.policy)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create outbox directory %s : %w", dir, err)
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	go o.retryLoop(ctx)
+	return o, nil
+}
+
+func (o *Outbox) Start(id string) error {
+	return o.inner.Start(id)
+}
+
+// Stop a record once all its pending events have been delivered, and forget about its queue
+func (o *Outbox) Stop(id string) (*pb.StopResult, error) {
+	q := o.queue(id)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, err := o.drain(q); err != nil {
+		return nil, fmt.Errorf("%d events of record %s are still pending : %w", len(q.pending), id, err)
+	}
+	reply, err := o.inner.Stop(id)
+	if err != nil {
+		return nil, err
+	}
+	o.mu.Lock()
+	if o.queues[id] == q {
+		delete(o.queues, id)
+	}
+	o.mu.Unlock()
+	return reply, nil
+}
+
+// Write the event to the outbox and try to deliver it.
+// The event is accepted as soon as it is on disk, even if the mixer is unreachable,
+// but an error is returned if the mixer rejects it
+func (o *Outbox) Send(evt *pb.Event) error {
+	q := o.queue(evt.RecordId)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) >= o.maxEvents {
+		if o.policy == Reject {
+			return fmt.Errorf("outbox of record %s is full (%d events)", evt.RecordId, len(q.pending))
+		}
+		dropped := q.pending[0]
+		slog.Warn(fmt.Sprintf("[Outbox] :: outbox of record %s is full, dropping event %s", evt.RecordId, dropped.EvtId))
+		q.pending = q.pending[1:]
+		if err := q.rewrite(); err != nil {
+			return err
+		}
+		o.addDeadLetter(dropped, fmt.Errorf("dropped, outbox of record %s is full (%d events)", evt.RecordId, o.maxEvents))
+	}
+	if err := q.append(evt); err != nil {
+		return fmt.Errorf("could not write event to the outbox : %w", err)
+	}
+	rejected, err := o.drain(q)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("[Outbox] :: mixer unreachable, %d events of record %s are pending : %s", len(q.pending), evt.RecordId, err))
+	}
+	return rejected[evt]
+}
+
+// Number of events waiting to be delivered for a record
+func (o *Outbox) Pending(id string) int {
+	q := o.queue(id)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+func (o *Outbox) addDeadLetter(evt *pb.Event, err error) {
+	if o.deadLetters != nil {
+		o.deadLetters.AddDeadLetter(evt, err)
+	}
+}
+
+func (o *Outbox) queue(id string) *recordQueue {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	q, ok := o.queues[id]
+	if !ok {
+		q = &recordQueue{path: jsonl.Path(o.dir, id)}
+		o.queues[id] = q
+	}
+	return q
+}
+
+// Send the pending events of a record in order, stopping at the first transient failure.
+// An event the mixer rejects would block the queue forever, it is dead lettered and skipped instead.
+// Returns the rejected events with their error. Must be called with the queue locked
+func (o *Outbox) drain(q *recordQueue) (map[*pb.Event]error, error) {
+	done := 0
+	var err error
+	var rejected map[*pb.Event]error
+	for _, evt := range q.pending {
+		if err = o.inner.Send(evt); err != nil {
+			if delivery.IsTransient(err) {
+				break
+			}
+			slog.Warn(fmt.Sprintf("[Outbox] :: mixer rejected event %s of record %s : %s", evt.EvtId, evt.RecordId, err))
+			o.addDeadLetter(evt, err)
+			if rejected == nil {
+				rejected = map[*pb.Event]error{}
+			}
+			rejected[evt] = err
+			err = nil
+		}
+		done++
+	}
+	if done == 0 {
+		return rejected, err
+	}
+	q.pending = q.pending[done:]
+	if rwErr := q.rewrite(); rwErr != nil {
+		// Delivered events would be sent again after a restart, which the mixer can handle better than a loss
+		slog.Error(fmt.Sprintf("[Outbox] :: could not update outbox file %s : %s", q.path, rwErr))
+	}
+	return rejected, err
+}
+
+// Periodically retry the delivery of pending events
+func (o *Outbox) retryLoop(ctx context.Context) {
+	ticker := time.NewTicker(o.retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			o.drainAll()
+		}
+	}
+}
+
+func (o *Outbox) drainAll() {
+	o.mu.Lock()
+	queues := make([]*recordQueue, 0, len(o.queues))
+	for _, q := range o.queues {
+		queues = append(queues, q)
+	}
+	o.mu.Unlock()
+	for _, q := range queues {
+		q.mu.Lock()
+		if len(q.pending) > 0 {
+			_, _ = o.drain(q)
+		}
+		q.mu.Unlock()
+	}
+}
+
+// Load the events left by a previous process
+func (o *Outbox) load() error {
+	files, err := filepath.Glob(filepath.Join(o.dir, "*"+jsonl.FileExt))
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		id, err := jsonl.RecordId(path)
+		if err != nil {
+			continue
+		}
+		q := o.queue(id)
+		if q.pending, err = readEvents(path); err != nil {
+			return fmt.Errorf("could not read outbox file %s : %w", path, err)
+		}
+		if len(q.pending) > 0 {
+			slog.Info(fmt.Sprintf("[Outbox] :: %d pending events loaded for record %s", len(q.pending), id))
+		}
+	}
+	return nil
+}
+
+func readEvents(path string) ([]*pb.Event, error) {
+	var events []*pb.Event
+	err := jsonl.Read(path, func(line []byte) error {
+		evt := &pb.Event{}
+		if err := protojson.Unmarshal(line, evt); err != nil {
+			return err
+		}
+		events = append(events, evt)
+		return nil
+	})
+	return events, err
+}
+
+// Append an event to the queue and its file
+func (q *recordQueue) append(evt *pb.Event) error {
+	line, err := protojson.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	// The event must survive a crash once accepted
+	if err := jsonl.Append(q.path, line, true); err != nil {
+		return err
+	}
+	q.pending = append(q.pending, evt)
+	return nil
+}
+
+// Replace the file content with the pending events
+func (q *recordQueue) rewrite() error {
+	lines := make([][]byte, 0, len(q.pending))
+	for _, evt := range q.pending {
+		line, err := protojson.Marshal(evt)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+	}
+	return jsonl.Rewrite(q.path, lines)
+}
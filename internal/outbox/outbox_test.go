@@ -0,0 +1,179 @@
+package outbox
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"os"
+	"path/filepath"
+	pb "roll20-audio-bouncer/proto"
+	"testing"
+	"time"
+)
+
+func newTestOutbox(t *testing.T, m Mixer, dir string, opts ...Option) *Outbox {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	// Retries are triggered manually
+	o, err := NewOutbox(ctx, m, dir, append([]Option{WithRetryInterval(time.Hour)}, opts...)...)
+	assert.NoError(t, err)
+	return o
+}
+
+func TestOutbox_Delivered(t *testing.T) {
+	m := &switchMixer{}
+	dir := t.TempDir()
+	o := newTestOutbox(t, m, dir)
+	assert.NoError(t, o.Send(&pb.Event{RecordId: "1", EvtId: "a"}))
+	assert.Len(t, m.events, 1)
+	assert.Equal(t, 0, o.Pending("1"))
+	// Nothing left on disk
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Empty(t, files)
+}
+
+// Events must be kept while the mixer is down, and delivered in order once it's back
+func TestOutbox_MixerDown(t *testing.T) {
+	m := &switchMixer{down: true}
+	o := newTestOutbox(t, m, t.TempDir())
+	for _, id := range []string{"a", "b", "c"} {
+		assert.NoError(t, o.Send(&pb.Event{RecordId: "1", EvtId: id}))
+	}
+	assert.Equal(t, 3, o.Pending("1"))
+	m.down = false
+	o.drainAll()
+	assert.Equal(t, 0, o.Pending("1"))
+	assert.Len(t, m.events, 3)
+	for i, id := range []string{"a", "b", "c"} {
+		assert.Equal(t, id, m.events[i].EvtId)
+	}
+}
+
+// Pending events must survive a crash
+func TestOutbox_Recovery(t *testing.T) {
+	m := &switchMixer{down: true}
+	dir := t.TempDir()
+	o := newTestOutbox(t, m, dir)
+	assert.NoError(t, o.Send(&pb.Event{RecordId: "record/1", EvtId: "a", VolumeDeltaDb: -3}))
+	assert.NoError(t, o.Send(&pb.Event{RecordId: "record/1", EvtId: "b"}))
+
+	restarted := newTestOutbox(t, m, dir)
+	assert.Equal(t, 2, restarted.Pending("record/1"))
+	m.down = false
+	restarted.drainAll()
+	assert.Len(t, m.events, 2)
+	assert.Equal(t, -3.0, m.events[0].VolumeDeltaDb)
+}
+
+func TestOutbox_CorruptedLine(t *testing.T) {
+	dir := t.TempDir()
+	content := `{"recordId":"1","evtId":"a"}` + "\n" + `{"recordId":"1","ev`
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "1.jsonl"), []byte(content), 0o644))
+	o := newTestOutbox(t, &switchMixer{down: true}, dir)
+	assert.Equal(t, 1, o.Pending("1"))
+}
+
+func TestOutbox_DropOldest(t *testing.T) {
+	m := &switchMixer{down: true}
+	letters := &deadLetters{}
+	o := newTestOutbox(t, m, t.TempDir(), WithLimit(2, DropOldest), WithDeadLetters(letters))
+	for _, id := range []string{"a", "b", "c"} {
+		assert.NoError(t, o.Send(&pb.Event{RecordId: "1", EvtId: id}))
+	}
+	assert.Equal(t, 2, o.Pending("1"))
+	// Dropped events aren't lost silently
+	assert.Len(t, letters.events, 1)
+	assert.Equal(t, "a", letters.events[0].EvtId)
+	m.down = false
+	o.drainAll()
+	assert.Equal(t, "b", m.events[0].EvtId)
+}
+
+// An event the mixer rejects must not block the following ones
+func TestOutbox_Rejected(t *testing.T) {
+	m := &switchMixer{down: true, rejected: map[string]bool{"b": true}}
+	letters := &deadLetters{}
+	o := newTestOutbox(t, m, t.TempDir(), WithDeadLetters(letters))
+	for _, id := range []string{"a", "b", "c"} {
+		assert.NoError(t, o.Send(&pb.Event{RecordId: "1", EvtId: id}))
+	}
+	m.down = false
+	o.drainAll()
+	assert.Equal(t, 0, o.Pending("1"))
+	assert.Len(t, m.events, 2)
+	assert.Equal(t, "c", m.events[1].EvtId)
+	assert.Len(t, letters.events, 1)
+	assert.Equal(t, "b", letters.events[0].EvtId)
+	// The sender is told about an event rejected right away
+	assert.Error(t, o.Send(&pb.Event{RecordId: "1", EvtId: "b"}))
+	assert.Equal(t, 0, o.Pending("1"))
+}
+
+func TestOutbox_Reject(t *testing.T) {
+	m := &switchMixer{down: true}
+	o := newTestOutbox(t, m, t.TempDir(), WithLimit(2, Reject))
+	assert.NoError(t, o.Send(&pb.Event{RecordId: "1", EvtId: "a"}))
+	assert.NoError(t, o.Send(&pb.Event{RecordId: "1", EvtId: "b"}))
+	assert.Error(t, o.Send(&pb.Event{RecordId: "1", EvtId: "c"}))
+	assert.Equal(t, 2, o.Pending("1"))
+}
+
+func TestOutbox_UnknownPolicy(t *testing.T) {
+	_, err := NewOutbox(context.Background(), &switchMixer{}, t.TempDir(), WithLimit(1, "test"))
+	assert.Error(t, err)
+}
+
+// A record can only stop once all its events are delivered
+func TestOutbox_Stop(t *testing.T) {
+	m := &switchMixer{down: true}
+	o := newTestOutbox(t, m, t.TempDir())
+	assert.NoError(t, o.Send(&pb.Event{RecordId: "1", EvtId: "a"}))
+	_, err := o.Stop("1")
+	assert.Error(t, err)
+	assert.Equal(t, 0, m.stops)
+	m.down = false
+	_, err = o.Stop("1")
+	assert.NoError(t, err)
+	assert.Len(t, m.events, 1)
+	assert.Equal(t, 1, m.stops)
+	// The queue of a stopped record is released
+	assert.NotContains(t, o.queues, "1")
+}
+
+// Mixer that can be turned off
+type switchMixer struct {
+	down bool
+	// Events rejected by the mixer, by ID
+	rejected map[string]bool
+	events   []*pb.Event
+	stops    int
+}
+
+func (m *switchMixer) Start(id string) error {
+	return nil
+}
+
+func (m *switchMixer) Stop(id string) (*pb.StopResult, error) {
+	m.stops++
+	return &pb.StopResult{StopReply: &pb.StopReply{}}, nil
+}
+
+func (m *switchMixer) Send(evt *pb.Event) error {
+	if m.down {
+		return status.Error(codes.Unavailable, "Test")
+	}
+	if m.rejected[evt.EvtId] {
+		return status.Error(codes.InvalidArgument, "Test")
+	}
+	m.events = append(m.events, evt)
+	return nil
+}
+
+type deadLetters struct {
+	events []*pb.Event
+}
+
+func (d *deadLetters) AddDeadLetter(evt *pb.Event, err error) {
+	d.events = append(d.events, evt)
+}
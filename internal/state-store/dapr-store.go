@@ -0,0 +1,85 @@
+package state_store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// State store backed by a Dapr state store component, using the sidecar HTTP API.
+// Values must be JSON documents
+type DaprStore struct {
+	// Base URL of the state store, http://localhost:<port>/v1.0/state/<store>
+	baseUrl string
+	client  *http.Client
+}
+
+type daprStateItem struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+func NewDaprStore(daprHttpPort int, storeName string) *DaprStore {
+	return &DaprStore{
+		baseUrl: fmt.Sprintf("http://localhost:%d/v1.0/state/%s", daprHttpPort, url.PathEscape(storeName)),
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (ds *DaprStore) Get(key string) ([]byte, error) {
+	res, err := ds.client.Get(ds.baseUrl + "/" + url.PathEscape(key))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	// Dapr answers with no content when the key doesn't exist
+	if res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not get key %s from state store, status %d : %s", key, res.StatusCode, body)
+	}
+	return body, nil
+}
+
+func (ds *DaprStore) Set(key string, value []byte) error {
+	payload, err := json.Marshal([]daprStateItem{{Key: key, Value: value}})
+	if err != nil {
+		return err
+	}
+	res, err := ds.client.Post(ds.baseUrl, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("could not save key %s in state store, status %d : %s", key, res.StatusCode, body)
+	}
+	return nil
+}
+
+func (ds *DaprStore) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, ds.baseUrl+"/"+url.PathEscape(key), nil)
+	if err != nil {
+		return err
+	}
+	res, err := ds.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("could not delete key %s from state store, status %d : %s", key, res.StatusCode, body)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package state_store
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Fake Dapr sidecar, implementing the state API of a single store
+func newFakeSidecar(t *testing.T) (*DaprStore, func()) {
+	values := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, _ := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/v1.0/state/store/"))
+		switch r.Method {
+		case http.MethodGet:
+			value, ok := values[key]
+			if !ok {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.Write(value)
+		case http.MethodPost:
+			var items []daprStateItem
+			body, _ := io.ReadAll(r.Body)
+			if err := json.Unmarshal(body, &items); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			for _, item := range items {
+				values[item.Key] = item.Value
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			delete(values, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	u, err := url.Parse(srv.URL)
+	assert.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	assert.NoError(t, err)
+	return NewDaprStore(port, "store"), srv.Close
+}
+
+func TestDaprStore_SetGetDelete(t *testing.T) {
+	ds, closeFn := newFakeSidecar(t)
+	defer closeFn()
+	value, err := ds.Get("record/1")
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+	err = ds.Set("record/1", []byte(`{"a":1}`))
+	assert.NoError(t, err)
+	value, err = ds.Get("record/1")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"a":1}`, string(value))
+	err = ds.Delete("record/1")
+	assert.NoError(t, err)
+	value, err = ds.Get("record/1")
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+}
+
+// Values must be JSON documents
+func TestDaprStore_SetInvalidValue(t *testing.T) {
+	ds, closeFn := newFakeSidecar(t)
+	defer closeFn()
+	err := ds.Set("a", []byte("not json"))
+	assert.Error(t, err)
+}
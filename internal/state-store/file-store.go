@@ -0,0 +1,67 @@
+package state_store
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Local state store, saving each key as a file in a directory.
+// Meant for development and testing purposes
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create state store directory %s : %w", dir, err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (fs *FileStore) path(key string) string {
+	// Keys may contain characters that aren't allowed in a file name
+	return filepath.Join(fs.dir, url.PathEscape(key)+".json")
+}
+
+func (fs *FileStore) Get(key string) ([]byte, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	value, err := os.ReadFile(fs.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (fs *FileStore) Set(key string, value []byte) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	// Write in a temporary file first, so that a crash can't leave a truncated value behind
+	tmp, err := os.CreateTemp(fs.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(value); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fs.path(key))
+}
+
+func (fs *FileStore) Delete(key string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	err := os.Remove(fs.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,33 @@
+package state_store
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFileStore_GetMissingKey(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	value, err := fs.Get("a")
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+}
+
+func TestFileStore_SetGetDelete(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+	// Keys are not always valid file names
+	err = fs.Set("record/1", []byte(`{"a":1}`))
+	assert.NoError(t, err)
+	value, err := fs.Get("record/1")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, string(value))
+	err = fs.Delete("record/1")
+	assert.NoError(t, err)
+	value, err = fs.Get("record/1")
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+	// Deleting a missing key isn't an error
+	err = fs.Delete("record/1")
+	assert.NoError(t, err)
+}
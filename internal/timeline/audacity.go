@@ -0,0 +1,37 @@
+package timeline
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Write an Audacity label track : a region label for each clip, and a point label for each volume change
+func writeAudacity(w io.Writer, tl *Timeline) error {
+	type label struct {
+		start, end time.Duration
+		text       string
+	}
+	var labels []label
+	for _, c := range tl.Clips {
+		text := fmt.Sprintf("%s (%.1f dB)", c.Title, c.Volume[0].Db)
+		if c.SourceOffset > 0 {
+			text += fmt.Sprintf(" from %s", c.SourceOffset.Round(time.Millisecond))
+		}
+		labels = append(labels, label{start: c.Start, end: c.End, text: text})
+		for _, v := range c.Volume[1:] {
+			labels = append(labels, label{start: v.Offset, end: v.Offset, text: fmt.Sprintf("%s volume %.1f dB", c.Title, v.Db)})
+		}
+	}
+	sort.SliceStable(labels, func(i, j int) bool { return labels[i].start < labels[j].start })
+	bw := bufio.NewWriter(w)
+	for _, l := range labels {
+		// Labels are tab separated, and can't span multiple lines
+		text := strings.NewReplacer("\t", " ", "\n", " ").Replace(l.text)
+		fmt.Fprintf(bw, "%.6f\t%.6f\t%s\n", l.start.Seconds(), l.end.Seconds(), text)
+	}
+	return bw.Flush()
+}
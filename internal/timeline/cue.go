@@ -0,0 +1,47 @@
+package timeline
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// CUE sheets count time in frames, 75 per second
+const cueFramesPerSec = 75
+
+// Write a CUE sheet with a track for each clip.
+// The asset, seek position and volume of a clip are kept as remarks
+func writeCue(w io.Writer, tl *Timeline, opts Options) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "REM RECORD %s\n", cueQuote(tl.RecordId))
+	fmt.Fprintf(bw, "REM DATE %s\n", tl.Start.UTC().Format(time.RFC3339))
+	fmt.Fprintf(bw, "TITLE %s\n", cueQuote("Roll20 jukebox "+tl.RecordId))
+	fmt.Fprintf(bw, "FILE %s WAVE\n", cueQuote(opts.AudioFile))
+	for i, c := range tl.Clips {
+		fmt.Fprintf(bw, "  TRACK %02d AUDIO\n", i+1)
+		fmt.Fprintf(bw, "    TITLE %s\n", cueQuote(c.Title))
+		fmt.Fprintf(bw, "    REM ASSET %s\n", cueQuote(c.AssetUrl))
+		if c.SourceOffset > 0 {
+			fmt.Fprintf(bw, "    REM SEEK %s\n", cueTime(c.SourceOffset))
+		}
+		fmt.Fprintf(bw, "    REM VOLUME %.2f dB\n", c.Volume[0].Db)
+		if c.Loop {
+			fmt.Fprintf(bw, "    REM LOOP\n")
+		}
+		fmt.Fprintf(bw, "    INDEX 01 %s\n", cueTime(c.Start))
+	}
+	return bw.Flush()
+}
+
+// Format an offset as mm:ss:ff
+func cueTime(d time.Duration) string {
+	frames := d * cueFramesPerSec / time.Second
+	return fmt.Sprintf("%02d:%02d:%02d", frames/(60*cueFramesPerSec), frames/cueFramesPerSec%60, frames%cueFramesPerSec)
+}
+
+// CUE strings can't contain double quotes
+func cueQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+}
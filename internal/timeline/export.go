@@ -0,0 +1,77 @@
+package timeline
+
+import (
+	"fmt"
+	"io"
+)
+
+// Export format of a timeline
+type Format string
+
+const (
+	// CUE sheet, one track per clip
+	FormatCue Format = "cue"
+	// Audacity label track
+	FormatAudacity Format = "audacity"
+	// OpenTimelineIO JSON document
+	FormatOtio Format = "otio"
+	// Reaper project
+	FormatReaper Format = "rpp"
+)
+
+// Reaper version projects are written for, if not set in the options
+const DefaultReaperVersion = "6.0"
+
+// Optional parameters of an export
+type Options struct {
+	// Name of the recorded audio file the timeline is aligned with
+	AudioFile string
+	// Local directory the assets were downloaded to. Assets are referenced by URL if empty
+	AssetDir string
+	// Reaper version of the projects, DefaultReaperVersion if empty
+	ReaperVersion string
+}
+
+// Usual file extension of a format
+func (f Format) Extension() string {
+	switch f {
+	case FormatAudacity:
+		return ".txt"
+	default:
+		return "." + string(f)
+	}
+}
+
+// MIME type of a format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatOtio:
+		return "application/json"
+	case FormatCue:
+		return "application/x-cue"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// Write a timeline in the given format
+func Export(w io.Writer, tl *Timeline, format Format, opts Options) error {
+	if opts.AudioFile == "" {
+		opts.AudioFile = tl.RecordId + ".wav"
+	}
+	if opts.ReaperVersion == "" {
+		opts.ReaperVersion = DefaultReaperVersion
+	}
+	switch format {
+	case FormatCue:
+		return writeCue(w, tl, opts)
+	case FormatAudacity:
+		return writeAudacity(w, tl)
+	case FormatOtio:
+		return writeOtio(w, tl)
+	case FormatReaper:
+		return writeReaper(w, tl, opts)
+	default:
+		return fmt.Errorf("unknown export format %s", format)
+	}
+}
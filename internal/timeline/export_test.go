@@ -0,0 +1,93 @@
+package timeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func exportSample(t *testing.T, format Format) string {
+	tl, err := FromJournal("1", sampleJournal())
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, Export(&buf, tl, format, Options{}))
+	return buf.String()
+}
+
+func TestExport_UnknownFormat(t *testing.T) {
+	assert.Error(t, Export(&bytes.Buffer{}, &Timeline{}, "mp3", Options{}))
+}
+
+func TestExport_Cue(t *testing.T) {
+	expected := `REM RECORD "1"
+REM DATE 2024-01-01T20:00:00Z
+TITLE "Roll20 jukebox 1"
+FILE "1.wav" WAVE
+  TRACK 01 AUDIO
+    TITLE "b.mp3"
+    REM ASSET "https://x/b.mp3?sig=1"
+    REM SEEK 00:05:00
+    REM VOLUME 0.00 dB
+    REM LOOP
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Tavern"
+    REM ASSET "https://x/a.mp3"
+    REM VOLUME -6.00 dB
+    INDEX 01 00:10:00
+  TRACK 03 AUDIO
+    TITLE "Tavern"
+    REM ASSET "https://x/a.mp3"
+    REM SEEK 01:00:00
+    REM VOLUME -6.00 dB
+    INDEX 01 00:20:00
+`
+	assert.Equal(t, expected, exportSample(t, FormatCue))
+}
+
+func TestCueTime(t *testing.T) {
+	assert.Equal(t, "61:01:37", cueTime(time.Hour+time.Minute+time.Second+500*time.Millisecond))
+}
+
+func TestExport_Audacity(t *testing.T) {
+	expected := "0.000000\t40.000000\tb.mp3 (0.0 dB) from 5s\n" +
+		"10.000000\t20.000000\tTavern (-6.0 dB)\n" +
+		"20.000000\t30.000000\tTavern (-6.0 dB) from 1m0s\n" +
+		"25.000000\t25.000000\tTavern volume -9.0 dB\n"
+	assert.Equal(t, expected, exportSample(t, FormatAudacity))
+}
+
+func TestExport_Otio(t *testing.T) {
+	var doc struct {
+		Schema string `json:"OTIO_SCHEMA"`
+		Tracks struct {
+			Children []struct {
+				Name     string `json:"name"`
+				Children []struct {
+					Schema      string `json:"OTIO_SCHEMA"`
+					SourceRange struct {
+						Start    struct{ Value float64 } `json:"start_time"`
+						Duration struct{ Value float64 } `json:"duration"`
+					} `json:"source_range"`
+				} `json:"children"`
+			} `json:"children"`
+			Markers []any `json:"markers"`
+		} `json:"tracks"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(exportSample(t, FormatOtio)), &doc))
+	assert.Equal(t, "Timeline.1", doc.Schema)
+	assert.Len(t, doc.Tracks.Markers, 5)
+	assert.Len(t, doc.Tracks.Children, 2)
+	assert.Equal(t, "b.mp3", doc.Tracks.Children[0].Name)
+	a := doc.Tracks.Children[1]
+	assert.Equal(t, "Tavern", a.Name)
+	assert.Len(t, a.Children, 3)
+	// A gap until the track starts playing
+	assert.Equal(t, "Gap.1", a.Children[0].Schema)
+	assert.Equal(t, float64(10000), a.Children[0].SourceRange.Duration.Value)
+	assert.Equal(t, "Clip.2", a.Children[2].Schema)
+	assert.Equal(t, float64(60000), a.Children[2].SourceRange.Start.Value)
+	assert.Equal(t, float64(10000), a.Children[2].SourceRange.Duration.Value)
+}
@@ -0,0 +1,117 @@
+package timeline
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// OpenTimelineIO times are expressed in milliseconds
+const otioRate = 1000
+
+type otioObject map[string]any
+
+func otioTime(d time.Duration) otioObject {
+	return otioObject{"OTIO_SCHEMA": "RationalTime.1", "rate": otioRate, "value": float64(d.Milliseconds())}
+}
+
+func otioRange(start, duration time.Duration) otioObject {
+	return otioObject{"OTIO_SCHEMA": "TimeRange.1", "start_time": otioTime(start), "duration": otioTime(duration)}
+}
+
+// Write an OpenTimelineIO document, with an audio track for each jukebox track.
+// Each event sent to the mixer is also kept as a marker of the timeline
+func writeOtio(w io.Writer, tl *Timeline) error {
+	tracks := make([]otioObject, 0)
+	for _, id := range tl.TrackIds() {
+		var children []otioObject
+		var name string
+		var position time.Duration
+		for _, c := range tl.Clips {
+			if c.TrackId != id {
+				continue
+			}
+			name = c.Title
+			if c.Start > position {
+				children = append(children, otioObject{
+					"OTIO_SCHEMA":  "Gap.1",
+					"name":         "",
+					"source_range": otioRange(0, c.Start-position),
+					"effects":      []any{},
+					"markers":      []any{},
+					"metadata":     otioObject{},
+				})
+			}
+			volume := make([]otioObject, 0, len(c.Volume))
+			for _, v := range c.Volume {
+				volume = append(volume, otioObject{"offset": v.Offset.Seconds(), "db": v.Db})
+			}
+			children = append(children, otioObject{
+				"OTIO_SCHEMA":  "Clip.2",
+				"name":         c.Title,
+				"source_range": otioRange(c.SourceOffset, c.End-c.Start),
+				"media_references": otioObject{
+					"DEFAULT_MEDIA": otioObject{
+						"OTIO_SCHEMA":     "ExternalReference.1",
+						"name":            c.Title,
+						"target_url":      c.AssetUrl,
+						"available_range": nil,
+						"metadata":        otioObject{},
+					},
+				},
+				"active_media_reference_key": "DEFAULT_MEDIA",
+				"effects":                    []any{},
+				"markers":                    []any{},
+				"metadata": otioObject{
+					"roll20": otioObject{"trackId": c.TrackId, "loop": c.Loop, "volume": volume},
+				},
+			})
+			position = c.End
+		}
+		tracks = append(tracks, otioObject{
+			"OTIO_SCHEMA":  "Track.1",
+			"name":         name,
+			"kind":         "Audio",
+			"source_range": nil,
+			"children":     children,
+			"effects":      []any{},
+			"markers":      []any{},
+			"metadata":     otioObject{"roll20": otioObject{"trackId": id}},
+		})
+	}
+
+	markers := make([]otioObject, 0, len(tl.Markers))
+	for _, m := range tl.Markers {
+		markers = append(markers, otioObject{
+			"OTIO_SCHEMA":  "Marker.2",
+			"name":         m.Type.String() + " " + m.Title,
+			"color":        "GREEN",
+			"comment":      "",
+			"marked_range": otioRange(m.Offset, 0),
+			"metadata": otioObject{
+				"roll20": otioObject{"trackId": m.TrackId, "type": m.Type.String(), "volumeDb": m.VolumeDb, "seekPosition": m.SeekPosition.Seconds()},
+			},
+		})
+	}
+
+	doc := otioObject{
+		"OTIO_SCHEMA":       "Timeline.1",
+		"name":              "Roll20 jukebox " + tl.RecordId,
+		"global_start_time": nil,
+		"metadata": otioObject{
+			"roll20": otioObject{"recordId": tl.RecordId, "start": tl.Start, "duration": tl.Duration.Seconds()},
+		},
+		"tracks": otioObject{
+			"OTIO_SCHEMA":  "Stack.1",
+			"name":         "tracks",
+			"source_range": nil,
+			"children":     tracks,
+			"effects":      []any{},
+			"markers":      markers,
+			"metadata":     otioObject{},
+		},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,106 @@
+package timeline
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Reaper source type of an asset, from its extension
+func reaperSourceType(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".wav":
+		return "WAVE"
+	case ".ogg":
+		return "VORBIS"
+	case ".flac":
+		return "FLAC"
+	default:
+		// Roll20 jukebox assets are mostly MP3
+		return "MP3"
+	}
+}
+
+// Where Reaper should look for an asset : its URL, or a file of the asset directory
+func reaperAssetPath(c *Clip, opts Options) string {
+	if opts.AssetDir == "" {
+		return c.AssetUrl
+	}
+	return filepath.Join(opts.AssetDir, assetName(c.AssetUrl))
+}
+
+// Write a Reaper project with a track for each jukebox track.
+// Each clip is an item placed at its position in the recording and starting at its seek position,
+// looping clips keep looping until the item ends, and the volume changes are a volume envelope of the track
+func writeReaper(w io.Writer, tl *Timeline, opts Options) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "<REAPER_PROJECT 0.1 %s %d\n", reaperQuote(opts.ReaperVersion), tl.Start.Unix())
+	fmt.Fprintf(bw, "  <NOTES 0 2\n")
+	fmt.Fprintf(bw, "  |Roll20 jukebox %s, recorded on %s\n", tl.RecordId, tl.Start.UTC().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(bw, "  >\n")
+	for _, id := range tl.TrackIds() {
+		var clips []*Clip
+		for i := range tl.Clips {
+			if tl.Clips[i].TrackId == id {
+				clips = append(clips, &tl.Clips[i])
+			}
+		}
+		fmt.Fprintf(bw, "  <TRACK\n")
+		fmt.Fprintf(bw, "    NAME %s\n", reaperQuote(clips[0].Title))
+		fmt.Fprintf(bw, "    <VOLENV2\n")
+		fmt.Fprintf(bw, "      ACT 1 -1\n")
+		fmt.Fprintf(bw, "      VIS 1 1 1\n")
+		fmt.Fprintf(bw, "      ARM 0\n")
+		// Volume changes are instant, hence square points
+		fmt.Fprintf(bw, "      DEFSHAPE 1 -1 -1\n")
+		for i, c := range clips {
+			for _, v := range c.Volume {
+				fmt.Fprintf(bw, "      PT %.6f %.6f 1\n", v.Offset.Seconds(), math.Pow(10, v.Db/20))
+			}
+			// The volume is held until the clip ends, so that the envelope doesn't depend on what comes next
+			if i == len(clips)-1 || clips[i+1].Start != c.End {
+				last := c.Volume[len(c.Volume)-1]
+				fmt.Fprintf(bw, "      PT %.6f %.6f 1\n", c.End.Seconds(), math.Pow(10, last.Db/20))
+			}
+		}
+		fmt.Fprintf(bw, "    >\n")
+		for _, c := range clips {
+			loop := 0
+			if c.Loop {
+				loop = 1
+			}
+			asset := reaperAssetPath(c, opts)
+			fmt.Fprintf(bw, "    <ITEM\n")
+			fmt.Fprintf(bw, "      POSITION %.6f\n", c.Start.Seconds())
+			fmt.Fprintf(bw, "      LENGTH %.6f\n", (c.End - c.Start).Seconds())
+			fmt.Fprintf(bw, "      LOOP %d\n", loop)
+			fmt.Fprintf(bw, "      NAME %s\n", reaperQuote(c.Title))
+			fmt.Fprintf(bw, "      SOFFS %.6f\n", c.SourceOffset.Seconds())
+			fmt.Fprintf(bw, "      <SOURCE %s\n", reaperSourceType(assetName(c.AssetUrl)))
+			fmt.Fprintf(bw, "        FILE %s\n", reaperQuote(asset))
+			fmt.Fprintf(bw, "      >\n")
+			fmt.Fprintf(bw, "    >\n")
+		}
+		fmt.Fprintf(bw, "  >\n")
+	}
+	fmt.Fprintf(bw, ">\n")
+	return bw.Flush()
+}
+
+// Reaper strings are quoted with whichever quote they don't contain
+func reaperQuote(s string) string {
+	switch {
+	case !strings.Contains(s, `"`):
+		return `"` + s + `"`
+	case !strings.Contains(s, "'"):
+		return "'" + s + "'"
+	case !strings.Contains(s, "`"):
+		return "`" + s + "`"
+	default:
+		return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+	}
+}
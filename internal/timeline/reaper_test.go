@@ -0,0 +1,62 @@
+package timeline
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestExport_Reaper(t *testing.T) {
+	out := exportSample(t, FormatReaper)
+	assert.True(t, strings.HasPrefix(out, `<REAPER_PROJECT 0.1 "6.0"`))
+	assert.Equal(t, strings.Count(out, "<"), strings.Count(out, ">"))
+	assert.Equal(t, 2, strings.Count(out, "<TRACK"))
+	assert.Equal(t, 3, strings.Count(out, "<ITEM"))
+
+	// Second track, a seeked clip and a volume change
+	tavern := out[strings.LastIndex(out, "<TRACK"):]
+	assert.Contains(t, tavern, `NAME "Tavern"`)
+	assert.Contains(t, tavern, "PT 10.000000 0.501187 1\n")
+	assert.Contains(t, tavern, "PT 25.000000 0.354813 1\n")
+	// Clips are bounded by volume points, but a clip directly followed by another one doesn't end with a point
+	assert.Contains(t, tavern, "PT 30.000000 0.354813 1\n")
+	assert.Equal(t, 1, strings.Count(tavern, "PT 20.000000"))
+	assert.Contains(t, out, "PT 40.000000 1.000000 1\n")
+	assert.Contains(t, tavern, "POSITION 20.000000\n      LENGTH 10.000000\n      LOOP 0\n")
+	assert.Contains(t, tavern, "SOFFS 60.000000\n")
+	assert.Contains(t, tavern, "<SOURCE MP3\n        FILE \"https://x/a.mp3\"\n")
+
+	// The first track loops over the whole recording
+	assert.Contains(t, out, "POSITION 0.000000\n      LENGTH 40.000000\n      LOOP 1\n")
+}
+
+// Assets can also be referenced from a local directory
+func TestExport_ReaperAssetDir(t *testing.T) {
+	tl, err := FromJournal("1", sampleJournal())
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, Export(&buf, tl, FormatReaper, Options{AssetDir: "/cache"}))
+	assert.Contains(t, buf.String(), `FILE "/cache/b.mp3"`)
+	assert.Contains(t, buf.String(), `FILE "/cache/a.mp3"`)
+}
+
+func TestExport_ReaperVersion(t *testing.T) {
+	tl, err := FromJournal("1", sampleJournal())
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, Export(&buf, tl, FormatReaper, Options{ReaperVersion: "7.0"}))
+	assert.True(t, strings.HasPrefix(buf.String(), `<REAPER_PROJECT 0.1 "7.0"`))
+}
+
+func TestReaperQuote(t *testing.T) {
+	assert.Equal(t, `"a"`, reaperQuote("a"))
+	assert.Equal(t, `'a "b"'`, reaperQuote(`a "b"`))
+	assert.Equal(t, "`a \"b\" 'c'`", reaperQuote(`a "b" 'c'`))
+}
+
+func TestReaperSourceType(t *testing.T) {
+	assert.Equal(t, "WAVE", reaperSourceType("a.WAV"))
+	assert.Equal(t, "VORBIS", reaperSourceType("a.ogg"))
+	assert.Equal(t, "MP3", reaperSourceType("a"))
+}
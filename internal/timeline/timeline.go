@@ -0,0 +1,243 @@
+package timeline
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"roll20-audio-bouncer/internal/journal"
+	pb "roll20-audio-bouncer/proto"
+	"sort"
+	"time"
+)
+
+// Volume of a track from a given offset of the recording
+type VolumePoint struct {
+	Offset time.Duration
+	Db     float64
+}
+
+// A continuous portion of a jukebox asset playing in the recording
+type Clip struct {
+	// Identity of the jukebox track
+	TrackId  string
+	Title    string
+	AssetUrl string
+	// Position of the clip in the recording
+	Start time.Duration
+	End   time.Duration
+	// Position in the asset the clip starts from
+	SourceOffset time.Duration
+	// Set if the track was looping at any point of the clip
+	Loop bool
+	// Volume at the start of the clip, followed by its changes within the clip
+	Volume []VolumePoint
+}
+
+// A single event sent to the mixer
+type Marker struct {
+	Offset  time.Duration
+	Type    pb.EventType
+	TrackId string
+	Title   string
+	// Volume of the track after the event
+	VolumeDb float64
+	// Position in the asset, for the events starting a clip
+	SeekPosition time.Duration
+}
+
+// What the jukebox played during a recording
+type Timeline struct {
+	RecordId string
+	// When the recording started
+	Start    time.Time
+	Duration time.Duration
+	Markers  []Marker
+	// Clips, sorted by start
+	Clips []Clip
+}
+
+// State of a track while going through the journal
+type trackState struct {
+	title    string
+	volumeDb float64
+	loop     bool
+	// Clip currently playing, nil if the track isn't playing
+	clip *Clip
+}
+
+// Build the timeline of the last recording of a record from its journal.
+// Only the events the mixer accepted are taken into account
+func FromJournal(id string, entries []journal.Entry) (*Timeline, error) {
+	// A record may have been recorded multiple times, only the last recording is relevant.
+	// Starts are only journaled when a recording begins
+	first := -1
+	for i, e := range entries {
+		if e.Kind == journal.KindStart && e.Error == "" {
+			first = i
+		}
+	}
+	if first < 0 {
+		return nil, fmt.Errorf("record %s never started", id)
+	}
+	tl := &Timeline{RecordId: id, Start: entries[first].Date}
+	tracks := map[string]*trackState{}
+	var order []string
+	var offset time.Duration
+	stopped := false
+	for _, e := range entries[first+1:] {
+		if e.Error != "" {
+			continue
+		}
+		if e.Kind == journal.KindStop {
+			offset = max(offset, e.Date.Sub(tl.Start))
+			stopped = true
+			break
+		}
+		if e.Kind != journal.KindEvent || e.Event == nil {
+			continue
+		}
+		// Events are placed when the state they come from was received, which may precede the recording start.
+		// The state date comes from the clock of the browser, which can't be compared to the recording start.
+		// Offsets are kept monotonic, as buffered states aren't applied in the order they were received
+		date := e.Date
+		if e.Event.ReceivedAt != nil {
+			date = e.Event.ReceivedAt.AsTime()
+		}
+		offset = max(offset, date.Sub(tl.Start))
+		if e.Event.Type == pb.EventType_SYNC {
+			tl.sync(tracks, &order, e.Event, offset)
+			continue
+		}
+
+		evt := e.Event
+		ts, ok := tracks[evt.EvtId]
+		if !ok {
+			ts = &trackState{}
+			tracks[evt.EvtId] = ts
+			order = append(order, evt.EvtId)
+		}
+		if e.Title != "" {
+			ts.title = e.Title
+		} else if ts.title == "" {
+			ts.title = assetName(evt.AssetUrl)
+		}
+		switch {
+		case evt.VolumeDb != nil:
+			ts.volumeDb = evt.GetVolumeDb()
+		case evt.Type == pb.EventType_VOLUME:
+			// Events journaled before they carried the absolute volume
+			ts.volumeDb += evt.VolumeDeltaDb
+		default:
+			// Before they carried the absolute volume, every other event had it as its delta
+			ts.volumeDb = evt.VolumeDeltaDb
+		}
+		ts.loop = evt.Loop
+		seek := time.Duration(evt.SeekPositionMs) * time.Millisecond
+
+		switch evt.Type {
+		case pb.EventType_PLAY, pb.EventType_RESUME:
+			tl.openClip(ts, evt, offset, seek)
+		case pb.EventType_SEEK:
+			// The asset continues from another position, which is a new clip
+			if ts.clip != nil {
+				tl.openClip(ts, evt, offset, seek)
+			}
+		case pb.EventType_STOP, pb.EventType_PAUSE:
+			tl.closeClip(ts, offset)
+		case pb.EventType_VOLUME:
+			if ts.clip != nil {
+				ts.clip.Volume = append(ts.clip.Volume, VolumePoint{Offset: offset, Db: ts.volumeDb})
+			}
+		case pb.EventType_LOOP:
+			if ts.clip != nil {
+				ts.clip.Loop = ts.clip.Loop || ts.loop
+			}
+		}
+		tl.Markers = append(tl.Markers, Marker{Offset: offset, Type: evt.Type, TrackId: evt.EvtId, Title: ts.title, VolumeDb: ts.volumeDb, SeekPosition: seek})
+	}
+	// Without a stop, the recording lasted at least until the last call made to the mixer
+	if !stopped && len(entries) > first+1 {
+		offset = max(offset, entries[len(entries)-1].Date.Sub(tl.Start))
+	}
+	tl.Duration = offset
+	// Tracks still playing at the end of the recording
+	for _, key := range order {
+		tl.closeClip(tracks[key], tl.Duration)
+	}
+	sort.SliceStable(tl.Clips, func(i, j int) bool { return tl.Clips[i].Start < tl.Clips[j].Start })
+	return tl, nil
+}
+
+// Start a new clip of a track, ending the one currently playing
+func (tl *Timeline) openClip(ts *trackState, evt *pb.Event, offset, seek time.Duration) {
+	tl.closeClip(ts, offset)
+	ts.clip = &Clip{
+		TrackId:      evt.EvtId,
+		Title:        ts.title,
+		AssetUrl:     evt.AssetUrl,
+		Start:        offset,
+		SourceOffset: seek,
+		Loop:         ts.loop,
+		Volume:       []VolumePoint{{Offset: offset, Db: ts.volumeDb}},
+	}
+}
+
+// Apply a full state sent to the mixer : the listed tracks are playing, and the other ones aren't
+func (tl *Timeline) sync(tracks map[string]*trackState, order *[]string, evt *pb.Event, offset time.Duration) {
+	playing := map[string]bool{}
+	for _, snap := range evt.Tracks {
+		playing[snap.EvtId] = true
+		ts, ok := tracks[snap.EvtId]
+		if !ok {
+			ts = &trackState{title: assetName(snap.AssetUrl)}
+			tracks[snap.EvtId] = ts
+			*order = append(*order, snap.EvtId)
+		}
+		ts.loop = snap.Loop
+		if ts.clip == nil {
+			ts.volumeDb = snap.VolumeDb
+			tl.openClip(ts, &pb.Event{EvtId: snap.EvtId, AssetUrl: snap.AssetUrl}, offset, time.Duration(snap.SeekPositionMs)*time.Millisecond)
+		} else if ts.volumeDb != snap.VolumeDb {
+			ts.volumeDb = snap.VolumeDb
+			ts.clip.Volume = append(ts.clip.Volume, VolumePoint{Offset: offset, Db: ts.volumeDb})
+		}
+	}
+	for _, key := range *order {
+		if !playing[key] {
+			tl.closeClip(tracks[key], offset)
+		}
+	}
+	tl.Markers = append(tl.Markers, Marker{Offset: offset, Type: evt.Type, Title: fmt.Sprintf("%d tracks playing", len(evt.Tracks))})
+}
+
+// End the clip currently playing for a track, if any
+func (tl *Timeline) closeClip(ts *trackState, offset time.Duration) {
+	if ts.clip == nil {
+		return
+	}
+	ts.clip.End = offset
+	tl.Clips = append(tl.Clips, *ts.clip)
+	ts.clip = nil
+}
+
+// Identities of the tracks of the timeline, in order of appearance
+func (tl *Timeline) TrackIds() []string {
+	var ids []string
+	seen := map[string]bool{}
+	for _, c := range tl.Clips {
+		if !seen[c.TrackId] {
+			seen[c.TrackId] = true
+			ids = append(ids, c.TrackId)
+		}
+	}
+	return ids
+}
+
+// Name of an asset, for tracks without a title
+func assetName(assetUrl string) string {
+	u, err := url.Parse(assetUrl)
+	if err != nil || u.Path == "" {
+		return assetUrl
+	}
+	return path.Base(u.Path)
+}
@@ -0,0 +1,118 @@
+package timeline
+
+import (
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"roll20-audio-bouncer/internal/journal"
+	pb "roll20-audio-bouncer/proto"
+	"testing"
+	"time"
+)
+
+var refDate = time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+
+// Journal entry of an event received at the given offset from refDate, from a browser whose clock is an hour late
+func eventEntry(offset time.Duration, evt *pb.Event, title string) journal.Entry {
+	evt.StateDate = timestamppb.New(refDate.Add(offset - time.Hour))
+	evt.ReceivedAt = timestamppb.New(refDate.Add(offset))
+	return journal.Entry{Date: refDate.Add(offset + 100*time.Millisecond), Kind: journal.KindEvent, Event: evt, Title: title}
+}
+
+// A session with two tracks : a is played, seeked, its volume lowered then stopped, b starts before the recording
+func sampleJournal() []journal.Entry {
+	return []journal.Entry{
+		{Date: refDate, Kind: journal.KindStart},
+		eventEntry(-5*time.Second, &pb.Event{EvtId: "b", Type: pb.EventType_PLAY, AssetUrl: "https://x/b.mp3?sig=1", SeekPositionMs: 5000, Loop: true}, ""),
+		eventEntry(10*time.Second, &pb.Event{EvtId: "a", Type: pb.EventType_PLAY, AssetUrl: "https://x/a.mp3", VolumeDeltaDb: -6}, "Tavern"),
+		eventEntry(20*time.Second, &pb.Event{EvtId: "a", Type: pb.EventType_SEEK, AssetUrl: "https://x/a.mp3", VolumeDeltaDb: -6, SeekPositionMs: 60000}, "Tavern"),
+		eventEntry(25*time.Second, &pb.Event{EvtId: "a", Type: pb.EventType_VOLUME, AssetUrl: "https://x/a.mp3", VolumeDeltaDb: -3}, "Tavern"),
+		// Not delivered, ignored
+		{Date: refDate.Add(26 * time.Second), Kind: journal.KindEvent, Event: &pb.Event{EvtId: "a", Type: pb.EventType_STOP}, Error: "Test"},
+		eventEntry(30*time.Second, &pb.Event{EvtId: "a", Type: pb.EventType_STOP, AssetUrl: "https://x/a.mp3", VolumeDeltaDb: -9}, "Tavern"),
+		{Date: refDate.Add(40 * time.Second), Kind: journal.KindStop},
+	}
+}
+
+func TestFromJournal(t *testing.T) {
+	tl, err := FromJournal("1", sampleJournal())
+	assert.NoError(t, err)
+	assert.Equal(t, refDate, tl.Start)
+	assert.Equal(t, 40*time.Second, tl.Duration)
+	assert.Len(t, tl.Markers, 5)
+	assert.Equal(t, []Clip{
+		{TrackId: "b", Title: "b.mp3", AssetUrl: "https://x/b.mp3?sig=1", Start: 0, End: 40 * time.Second, SourceOffset: 5 * time.Second, Loop: true, Volume: []VolumePoint{{Offset: 0, Db: 0}}},
+		{TrackId: "a", Title: "Tavern", AssetUrl: "https://x/a.mp3", Start: 10 * time.Second, End: 20 * time.Second, Volume: []VolumePoint{{Offset: 10 * time.Second, Db: -6}}},
+		{TrackId: "a", Title: "Tavern", AssetUrl: "https://x/a.mp3", Start: 20 * time.Second, End: 30 * time.Second, SourceOffset: time.Minute, Volume: []VolumePoint{{Offset: 20 * time.Second, Db: -6}, {Offset: 25 * time.Second, Db: -9}}},
+	}, tl.Clips)
+	assert.Equal(t, []string{"b", "a"}, tl.TrackIds())
+}
+
+// Events journaled without the date they were received at are placed when they were sent
+func TestFromJournal_NotReceived(t *testing.T) {
+	entries := []journal.Entry{
+		{Date: refDate, Kind: journal.KindStart},
+		{Date: refDate.Add(10 * time.Second), Kind: journal.KindEvent, Event: &pb.Event{EvtId: "a", Type: pb.EventType_PLAY}},
+		{Date: refDate.Add(20 * time.Second), Kind: journal.KindEvent, Event: &pb.Event{EvtId: "a", Type: pb.EventType_STOP}},
+	}
+	tl, err := FromJournal("1", entries)
+	assert.NoError(t, err)
+	assert.Len(t, tl.Clips, 1)
+	assert.Equal(t, 10*time.Second, tl.Clips[0].Start)
+	assert.Equal(t, 20*time.Second, tl.Clips[0].End)
+}
+
+func TestFromJournal_NeverStarted(t *testing.T) {
+	_, err := FromJournal("1", []journal.Entry{{Date: refDate, Kind: journal.KindStart, Error: "Test"}})
+	assert.Error(t, err)
+}
+
+// Only the last recording of a record is exported
+func TestFromJournal_Restarted(t *testing.T) {
+	entries := append(sampleJournal(), journal.Entry{Date: refDate.Add(time.Hour), Kind: journal.KindStart})
+	tl, err := FromJournal("1", entries)
+	assert.NoError(t, err)
+	assert.Equal(t, refDate.Add(time.Hour), tl.Start)
+	assert.Empty(t, tl.Clips)
+}
+
+// Without a stop, tracks are still playing at the last call made to the mixer
+func TestFromJournal_NotStopped(t *testing.T) {
+	entries := sampleJournal()
+	tl, err := FromJournal("1", entries[:len(entries)-1])
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second+100*time.Millisecond, tl.Duration)
+	assert.Equal(t, tl.Duration, tl.Clips[0].End)
+}
+
+// The absolute volume of an event takes precedence over its delta
+func TestFromJournal_AbsoluteVolume(t *testing.T) {
+	entries := []journal.Entry{
+		{Date: refDate, Kind: journal.KindStart},
+		eventEntry(0, &pb.Event{EvtId: "a", Type: pb.EventType_PLAY, VolumeDeltaDb: -6, VolumeDb: proto.Float64(-6)}, "Tavern"),
+		// The delta doesn't match the volume the mixer knows, the absolute volume does
+		eventEntry(time.Second, &pb.Event{EvtId: "a", Type: pb.EventType_VOLUME, VolumeDeltaDb: -3, VolumeDb: proto.Float64(-12)}, "Tavern"),
+		// Back at full scale
+		eventEntry(2*time.Second, &pb.Event{EvtId: "a", Type: pb.EventType_VOLUME, VolumeDeltaDb: 3, VolumeDb: proto.Float64(0)}, "Tavern"),
+	}
+	tl, err := FromJournal("1", entries)
+	assert.NoError(t, err)
+	assert.Equal(t, []VolumePoint{{Offset: 0, Db: -6}, {Offset: time.Second, Db: -12}, {Offset: 2 * time.Second, Db: 0}}, tl.Clips[0].Volume)
+}
+
+// A full state starts the tracks the timeline missed, and stops the other ones
+func TestFromJournal_Sync(t *testing.T) {
+	entries := []journal.Entry{
+		{Date: refDate, Kind: journal.KindStart},
+		eventEntry(0, &pb.Event{EvtId: "a", Type: pb.EventType_PLAY, AssetUrl: "https://x/a.mp3"}, "Tavern"),
+		eventEntry(10*time.Second, &pb.Event{Type: pb.EventType_SYNC, Tracks: []*pb.TrackSnapshot{{EvtId: "b", AssetUrl: "https://x/b.mp3", VolumeDb: -6, SeekPositionMs: 3000}}}, ""),
+		{Date: refDate.Add(20 * time.Second), Kind: journal.KindStop},
+	}
+	tl, err := FromJournal("1", entries)
+	assert.NoError(t, err)
+	assert.Equal(t, []Clip{
+		{TrackId: "a", Title: "Tavern", AssetUrl: "https://x/a.mp3", Start: 0, End: 10 * time.Second, Volume: []VolumePoint{{Offset: 0, Db: 0}}},
+		{TrackId: "b", Title: "b.mp3", AssetUrl: "https://x/b.mp3", Start: 10 * time.Second, End: 20 * time.Second, SourceOffset: 3 * time.Second, Volume: []VolumePoint{{Offset: 10 * time.Second, Db: -6}}},
+	}, tl.Clips)
+	assert.Equal(t, pb.EventType_SYNC, tl.Markers[1].Type)
+}
@@ -2,15 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"log"
 	"log/slog"
 	"os"
 	"roll20-audio-bouncer/controller"
+	"roll20-audio-bouncer/internal/capture"
+	"roll20-audio-bouncer/internal/delivery"
+	"roll20-audio-bouncer/internal/journal"
 	mixer_client "roll20-audio-bouncer/internal/mixer-client"
+	"roll20-audio-bouncer/internal/outbox"
+	state_store "roll20-audio-bouncer/internal/state-store"
 	jukebox_syncer "roll20-audio-bouncer/service/jukebox-syncer"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -27,26 +34,14 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	// Dapr client
-	daprPort := 50001
-	if envPort, err := strconv.ParseInt(os.Getenv("DAPR_GRPC_PORT"), 10, 32); err == nil && envPort != 0 {
-		daprPort = int(envPort)
-	}
-	slog.Info("[Main] :: Dapr port is " + strconv.Itoa(daprPort))
-	daprMixerId := os.Getenv("MIXER_APP_ID")
-	if daprMixerId == "" {
-		daprMixerId = DEFAULT_MIXER_DID
-	}
-	appPort := DEFAULT_APP_PORT
-	if envPort, err := strconv.ParseInt(os.Getenv("APP_PORT"), 10, 32); err == nil && envPort != 0 {
-		appPort = int(envPort)
-	}
+	cfg := loadConfig()
+	slog.Info("[Main] :: Dapr port is " + strconv.Itoa(cfg.daprGrpcPort))
 
 	mainCtx, cancel := context.WithCancel(context.Background())
 	// Graceful shutdown
 	defer cancel()
 	// Initialize controllers
-	evtCtrl, err := DI(mainCtx, fmt.Sprintf("localhost:%d", daprPort), daprMixerId)
+	ctrls, err := DI(mainCtx, cfg)
 	if err != nil {
 		panic(fmt.Errorf("failed to initialize event controller: %w", err))
 	}
@@ -72,23 +67,234 @@ func main() {
 	{
 		evt := v1.Group("/jukeboxsyncer")
 		{
-			evt.POST("/start", evtCtrl.Start)
-			evt.POST("/stop", evtCtrl.Stop)
-			evt.POST("/evt", evtCtrl.Handle)
+			evt.POST("/start", ctrls.evt.Start)
+			evt.POST("/stop", ctrls.evt.Stop)
+			evt.POST("/evt", ctrls.evt.Handle)
+			evt.POST("/heartbeat", ctrls.evt.Heartbeat)
+			evt.GET("/records/:id/stats", ctrls.evt.Stats)
+			evt.POST("/records/:id/resync", ctrls.evt.Resync)
+			evt.GET("/records/:id/output", ctrls.evt.Output)
+			evt.GET("/autostart", ctrls.autoStart.List)
+			evt.PUT("/autostart/:id", ctrls.autoStart.Allow)
+			evt.DELETE("/autostart/:id", ctrls.autoStart.Disallow)
+			evt.GET("/deadletters", ctrls.delivery.DeadLetters)
+			evt.GET("/records/:id/deadletters", ctrls.delivery.DeadLetters)
+			evt.GET("/streams", ctrls.delivery.Streams)
+			evt.GET("/records/:id/stream", ctrls.delivery.Streams)
+			if ctrls.journal != nil {
+				evt.GET("/records/:id/events", ctrls.journal.Events)
+				evt.GET("/records/:id/timeline", ctrls.journal.Timeline)
+				evt.POST("/records/:id/replay", ctrls.journal.Replay)
+			}
 		}
 	}
-	slog.Info(fmt.Sprintf("[Main] :: Starting server on port %d", appPort))
-	err = router.Run(fmt.Sprintf(":%d", appPort))
+	slog.Info(fmt.Sprintf("[Main] :: Starting server on port %d", cfg.appPort))
+	err = router.Run(fmt.Sprintf(":%d", cfg.appPort))
 	if err != nil {
 		log.Fatalf(err.Error())
 	}
 }
 
-func DI(ctx context.Context, daprAddress, mixerId string) (*controller.EventController, error) {
-	mixerApi, err := mixer_client.NewMixerClient(ctx, daprAddress, mixerId)
+// All the controllers of the app
+type controllers struct {
+	evt       *controller.EventController
+	delivery  *controller.DeliveryController
+	autoStart *controller.AutoStartController
+	// Only set if the journal is enabled
+	journal *controller.JournalController
+}
+
+func DI(ctx context.Context, cfg *config) (*controllers, error) {
+	mixerClient, err := mixer_client.NewMixerClient(ctx, fmt.Sprintf("localhost:%d", cfg.daprGrpcPort), cfg.daprMixerId,
+		mixer_client.WithKeepalive(cfg.keepalive, 10*time.Second),
+		mixer_client.WithReopenBackoff(500*time.Millisecond, cfg.reopenBackoff),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.mixerMaxRetries < 0 || cfg.breakerThreshold < 0 {
+		return nil, fmt.Errorf("the mixer retries and breaker threshold can't be negative")
+	}
+	reliable := delivery.NewReliableMixer(mixerClient,
+		delivery.WithRetries(cfg.mixerMaxRetries, 100*time.Millisecond, 2*time.Second),
+		delivery.WithCircuitBreaker(cfg.breakerThreshold, cfg.breakerCooldown),
+	)
+	// Events are written to a durable outbox before going through the delivery layer, if enabled
+	var mixerApi jukebox_syncer.MixerAPI = reliable
+	if cfg.outboxDir != "" {
+		slog.Info(fmt.Sprintf("[Main] :: Writing events to outbox %s", cfg.outboxDir))
+		mixerApi, err = outbox.NewOutbox(ctx, reliable, cfg.outboxDir,
+			outbox.WithLimit(cfg.outboxMaxEvents, outbox.DropPolicy(cfg.outboxDropPolicy)),
+			outbox.WithDeadLetters(reliable),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	opts, err := cfg.processing.Options()
 	if err != nil {
 		return nil, err
 	}
-	syncer := jukebox_syncer.NewJukeboxSyncer(mixerApi)
-	return controller.NewEventController(syncer), nil
+	opts = append(opts,
+		jukebox_syncer.WithAutoStart(cfg.autoStart...),
+		jukebox_syncer.WithMaxDuration(cfg.maxDuration),
+		jukebox_syncer.WithInactivityTimeout(cfg.idleTimeout),
+	)
+	if cfg.stateStoreName != "" {
+		slog.Info(fmt.Sprintf("[Main] :: Persisting state in Dapr state store %s", cfg.stateStoreName))
+		opts = append(opts, jukebox_syncer.WithStateStore(state_store.NewDaprStore(cfg.daprHttpPort, cfg.stateStoreName)))
+	} else if cfg.stateStoreDir != "" {
+		slog.Info(fmt.Sprintf("[Main] :: Persisting state in directory %s", cfg.stateStoreDir))
+		store, err := state_store.NewFileStore(cfg.stateStoreDir)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, jukebox_syncer.WithStateStore(store))
+	}
+	var journalCtrl *controller.JournalController
+	if cfg.journalDir != "" {
+		slog.Info(fmt.Sprintf("[Main] :: Journaling mixer calls in directory %s", cfg.journalDir))
+		j, err := journal.NewFileJournal(ctx, cfg.journalDir, journal.WithRetention(cfg.journalRetention))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, jukebox_syncer.WithJournal(j))
+		journalCtrl = controller.NewJournalController(j, mixerApi)
+	}
+	syncer := jukebox_syncer.NewJukeboxSyncer(mixerApi, opts...)
+	// A new stream means the mixer may have missed some deltas
+	mixerClient.OnStreamOpen(func(id string) {
+		// Replayed records aren't handled by the syncer
+		if err := syncer.Resync(id); err != nil && !errors.Is(err, jukebox_syncer.ErrNotStarted) {
+			slog.Warn(fmt.Sprintf("[Main] :: %s", err))
+		}
+	})
+	if err := syncer.Restore(); err != nil {
+		return nil, err
+	}
+	var evtOpts []controller.EventControllerOption
+	if cfg.captureDir != "" {
+		slog.Info(fmt.Sprintf("[Main] :: Capturing received states in directory %s", cfg.captureDir))
+		c, err := capture.NewFileCapture(cfg.captureDir)
+		if err != nil {
+			return nil, err
+		}
+		evtOpts = append(evtOpts, controller.WithStateCapture(c))
+	}
+	return &controllers{
+		evt:       controller.NewEventController(syncer, evtOpts...),
+		delivery:  controller.NewDeliveryController(reliable, mixerClient),
+		autoStart: controller.NewAutoStartController(syncer),
+		journal:   journalCtrl,
+	}, nil
+}
+
+// Runtime configuration, loaded from the env
+type config struct {
+	appPort      int
+	daprGrpcPort int
+	daprHttpPort int
+	daprMixerId  string
+	// How states are turned into events
+	processing jukebox_syncer.ProcessingConfig
+	// Name of the Dapr state store component used to persist the syncer state
+	stateStoreName string
+	// Local directory used to persist the syncer state when no Dapr state store is set
+	stateStoreDir string
+	// Number of retries of a failed mixer call
+	mixerMaxRetries int
+	// Consecutive mixer failures pausing the calls to the mixer, and for how long
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	// Local directory of the event outbox, disabled if empty
+	outboxDir string
+	// Maximum number of events kept per record in the outbox, and what to do once it's full
+	outboxMaxEvents  int
+	outboxDropPolicy string
+	// Local directory of the mixer calls journal, disabled if empty
+	journalDir string
+	// How long the journal of a stopped record is kept
+	journalRetention time.Duration
+	// Local directory where the received states are captured, disabled if empty
+	captureDir string
+	// Campaigns whose record is started by their first state
+	autoStart []string
+	// How long a record can stay without receiving anything before being stopped, disabled if zero
+	idleTimeout time.Duration
+	// Longest recording allowed, unlimited if zero
+	maxDuration time.Duration
+	// Interval of the keepalive pings sent to the mixer, and longest delay before re-opening a broken stream
+	keepalive     time.Duration
+	reopenBackoff time.Duration
+}
+
+func loadConfig() *config {
+	processing := jukebox_syncer.DefaultProcessingConfig()
+	processing.ReorderWindow = envDurationMs("REORDER_WINDOW_MS", processing.ReorderWindow)
+	processing.VolumeCurve = envString("VOLUME_CURVE", processing.VolumeCurve)
+	processing.CampaignVolumeCurves = os.Getenv("CAMPAIGN_VOLUME_CURVES")
+	processing.DebounceWindow = envDurationMs("DEBOUNCE_WINDOW_MS", processing.DebounceWindow)
+	processing.FlappingToggles = envInt("FLAPPING_MAX_TOGGLES", processing.FlappingToggles)
+	processing.FlappingPeriod = envDurationMs("FLAPPING_PERIOD_MS", processing.FlappingPeriod)
+	return &config{
+		processing:       processing,
+		appPort:          envInt("APP_PORT", DEFAULT_APP_PORT),
+		daprGrpcPort:     envInt("DAPR_GRPC_PORT", 50001),
+		daprHttpPort:     envInt("DAPR_HTTP_PORT", 3500),
+		daprMixerId:      envString("MIXER_APP_ID", DEFAULT_MIXER_DID),
+		stateStoreName:   os.Getenv("STATE_STORE_NAME"),
+		stateStoreDir:    os.Getenv("STATE_STORE_DIR"),
+		mixerMaxRetries:  envInt("MIXER_MAX_RETRIES", 3),
+		breakerThreshold: envInt("MIXER_BREAKER_THRESHOLD", 5),
+		breakerCooldown:  envDurationMs("MIXER_BREAKER_COOLDOWN_MS", 10*time.Second),
+		outboxDir:        os.Getenv("OUTBOX_DIR"),
+		outboxMaxEvents:  envInt("OUTBOX_MAX_EVENTS", 10000),
+		outboxDropPolicy: envString("OUTBOX_DROP_POLICY", string(outbox.DropOldest)),
+		journalDir:       os.Getenv("JOURNAL_DIR"),
+		journalRetention: time.Duration(envInt("JOURNAL_RETENTION_HOURS", 72)) * time.Hour,
+		captureDir:       os.Getenv("CAPTURE_DIR"),
+		autoStart:        envList("AUTO_START_CAMPAIGNS"),
+		idleTimeout:      envDurationMs("INACTIVITY_TIMEOUT_MS", 0),
+		maxDuration:      time.Duration(envInt("MAX_RECORDING_HOURS", 0)) * time.Hour,
+		keepalive:        envDurationMs("MIXER_KEEPALIVE_MS", mixer_client.DefaultKeepalive),
+		reopenBackoff:    envDurationMs("MIXER_MAX_REOPEN_BACKOFF_MS", 30*time.Second),
+	}
+}
+
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// Parse a comma separated list, ignoring empty items
+func envList(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Parse a number, zero included
+func envInt(name string, def int) int {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	if v, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32); err == nil {
+		return int(v)
+	}
+	return def
+}
+
+// Parse a positive number of milliseconds
+func envDurationMs(name string, def time.Duration) time.Duration {
+	if v, err := strconv.ParseInt(os.Getenv(name), 10, 64); err == nil && v >= 0 {
+		return time.Duration(v) * time.Millisecond
+	}
+	return def
 }
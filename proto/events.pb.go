@@ -9,6 +9,7 @@ package jukebox_syncer
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
 )
@@ -31,6 +32,11 @@ const (
 	EventType_SEEK        EventType = 5
 	EventType_VOLUME      EventType = 6
 	EventType_OTHER       EventType = 7
+	// The loop state of a track changed, the new state is carried by the loop field
+	EventType_LOOP EventType = 8
+	// Full state of the record, the tracks field lists every track that should be playing.
+	// Sent whenever the mixer may have lost track of the deltas, such as when a new stream is opened
+	EventType_SYNC EventType = 9
 )
 
 // Enum value maps for EventType.
@@ -44,6 +50,8 @@ var (
 		5: "SEEK",
 		6: "VOLUME",
 		7: "OTHER",
+		8: "LOOP",
+		9: "SYNC",
 	}
 	EventType_value = map[string]int32{
 		"UNSPECIFIED": 0,
@@ -54,6 +62,8 @@ var (
 		"SEEK":        5,
 		"VOLUME":      6,
 		"OTHER":       7,
+		"LOOP":        8,
+		"SYNC":        9,
 	}
 )
 
@@ -84,6 +94,88 @@ func (EventType) EnumDescriptor() ([]byte, []int) {
 	return file_proto_events_proto_rawDescGZIP(), []int{0}
 }
 
+// A track playing when a SYNC event was sent
+type TrackSnapshot struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	EvtId    string `protobuf:"bytes,1,opt,name=evtId,proto3" json:"evtId,omitempty"`
+	AssetUrl string `protobuf:"bytes,2,opt,name=assetUrl,proto3" json:"assetUrl,omitempty"`
+	Loop     bool   `protobuf:"varint,3,opt,name=loop,proto3" json:"loop,omitempty"`
+	// Absolute volume, in decibels relative to full scale
+	VolumeDb float64 `protobuf:"fixed64,4,opt,name=volumeDb,proto3" json:"volumeDb,omitempty"`
+	// Current position in the asset, in milliseconds
+	SeekPositionMs int64 `protobuf:"varint,5,opt,name=seekPositionMs,proto3" json:"seekPositionMs,omitempty"`
+}
+
+func (x *TrackSnapshot) Reset() {
+	*x = TrackSnapshot{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_events_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TrackSnapshot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TrackSnapshot) ProtoMessage() {}
+
+func (x *TrackSnapshot) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_events_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TrackSnapshot.ProtoReflect.Descriptor instead.
+func (*TrackSnapshot) Descriptor() ([]byte, []int) {
+	return file_proto_events_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *TrackSnapshot) GetEvtId() string {
+	if x != nil {
+		return x.EvtId
+	}
+	return ""
+}
+
+func (x *TrackSnapshot) GetAssetUrl() string {
+	if x != nil {
+		return x.AssetUrl
+	}
+	return ""
+}
+
+func (x *TrackSnapshot) GetLoop() bool {
+	if x != nil {
+		return x.Loop
+	}
+	return false
+}
+
+func (x *TrackSnapshot) GetVolumeDb() float64 {
+	if x != nil {
+		return x.VolumeDb
+	}
+	return 0
+}
+
+func (x *TrackSnapshot) GetSeekPositionMs() int64 {
+	if x != nil {
+		return x.SeekPositionMs
+	}
+	return 0
+}
+
 // Event message definition.
 type Event struct {
 	state         protoimpl.MessageState
@@ -96,16 +188,30 @@ type Event struct {
 	// Also asset ID
 	AssetUrl string `protobuf:"bytes,4,opt,name=assetUrl,proto3" json:"assetUrl,omitempty"`
 	Loop     bool   `protobuf:"varint,5,opt,name=loop,proto3" json:"loop,omitempty"`
-	// Volume change in decibels
+	// Volume change in decibels. Relative to the previous volume of the track for VOLUME events,
+	// relative to full scale otherwise
 	VolumeDeltaDb float64 `protobuf:"fixed64,6,opt,name=volumeDeltaDb,proto3" json:"volumeDeltaDb,omitempty"`
 	// Seek position in seconds
 	SeekPositionSec int64 `protobuf:"varint,7,opt,name=seekPositionSec,proto3" json:"seekPositionSec,omitempty"`
+	// Date of the Roll20 state this event was computed from
+	StateDate *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=stateDate,proto3" json:"stateDate,omitempty"`
+	// When the Roll20 state was received by the syncer
+	ReceivedAt *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=receivedAt,proto3" json:"receivedAt,omitempty"`
+	// Per-record monotonic sequence number, allowing the mixer to drop duplicates
+	Seq uint64 `protobuf:"varint,10,opt,name=seq,proto3" json:"seq,omitempty"`
+	// Seek position in milliseconds, more precise than seekPositionSec
+	SeekPositionMs int64 `protobuf:"varint,11,opt,name=seekPositionMs,proto3" json:"seekPositionMs,omitempty"`
+	// Absolute volume the track must be played at, in decibels relative to full scale.
+	// Unlike volumeDeltaDb, it can be applied regardless of the events the mixer missed
+	VolumeDb *float64 `protobuf:"fixed64,12,opt,name=volumeDb,proto3,oneof" json:"volumeDb,omitempty"`
+	// Tracks playing, for SYNC events only
+	Tracks []*TrackSnapshot `protobuf:"bytes,13,rep,name=tracks,proto3" json:"tracks,omitempty"`
 }
 
 func (x *Event) Reset() {
 	*x = Event{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_events_proto_msgTypes[0]
+		mi := &file_proto_events_proto_msgTypes[1]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -118,7 +224,7 @@ func (x *Event) String() string {
 func (*Event) ProtoMessage() {}
 
 func (x *Event) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_events_proto_msgTypes[0]
+	mi := &file_proto_events_proto_msgTypes[1]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -131,7 +237,7 @@ func (x *Event) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Event.ProtoReflect.Descriptor instead.
 func (*Event) Descriptor() ([]byte, []int) {
-	return file_proto_events_proto_rawDescGZIP(), []int{0}
+	return file_proto_events_proto_rawDescGZIP(), []int{1}
 }
 
 func (x *Event) GetRecordId() string {
@@ -183,6 +289,48 @@ func (x *Event) GetSeekPositionSec() int64 {
 	return 0
 }
 
+func (x *Event) GetStateDate() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StateDate
+	}
+	return nil
+}
+
+func (x *Event) GetReceivedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ReceivedAt
+	}
+	return nil
+}
+
+func (x *Event) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *Event) GetSeekPositionMs() int64 {
+	if x != nil {
+		return x.SeekPositionMs
+	}
+	return 0
+}
+
+func (x *Event) GetVolumeDb() float64 {
+	if x != nil && x.VolumeDb != nil {
+		return *x.VolumeDb
+	}
+	return 0
+}
+
+func (x *Event) GetTracks() []*TrackSnapshot {
+	if x != nil {
+		return x.Tracks
+	}
+	return nil
+}
+
 type EventReply struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -194,7 +342,7 @@ type EventReply struct {
 func (x *EventReply) Reset() {
 	*x = EventReply{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_events_proto_msgTypes[1]
+		mi := &file_proto_events_proto_msgTypes[2]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -207,7 +355,7 @@ func (x *EventReply) String() string {
 func (*EventReply) ProtoMessage() {}
 
 func (x *EventReply) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_events_proto_msgTypes[1]
+	mi := &file_proto_events_proto_msgTypes[2]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -220,7 +368,7 @@ func (x *EventReply) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use EventReply.ProtoReflect.Descriptor instead.
 func (*EventReply) Descriptor() ([]byte, []int) {
-	return file_proto_events_proto_rawDescGZIP(), []int{1}
+	return file_proto_events_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *EventReply) GetMessage() string {
@@ -241,7 +389,7 @@ type RecordRequest struct {
 func (x *RecordRequest) Reset() {
 	*x = RecordRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_events_proto_msgTypes[2]
+		mi := &file_proto_events_proto_msgTypes[3]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -254,7 +402,7 @@ func (x *RecordRequest) String() string {
 func (*RecordRequest) ProtoMessage() {}
 
 func (x *RecordRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_events_proto_msgTypes[2]
+	mi := &file_proto_events_proto_msgTypes[3]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -267,7 +415,7 @@ func (x *RecordRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RecordRequest.ProtoReflect.Descriptor instead.
 func (*RecordRequest) Descriptor() ([]byte, []int) {
-	return file_proto_events_proto_rawDescGZIP(), []int{2}
+	return file_proto_events_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *RecordRequest) GetId() string {
@@ -288,7 +436,7 @@ type RecordReply struct {
 func (x *RecordReply) Reset() {
 	*x = RecordReply{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_events_proto_msgTypes[3]
+		mi := &file_proto_events_proto_msgTypes[4]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -301,7 +449,7 @@ func (x *RecordReply) String() string {
 func (*RecordReply) ProtoMessage() {}
 
 func (x *RecordReply) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_events_proto_msgTypes[3]
+	mi := &file_proto_events_proto_msgTypes[4]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -314,7 +462,7 @@ func (x *RecordReply) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RecordReply.ProtoReflect.Descriptor instead.
 func (*RecordReply) Descriptor() ([]byte, []int) {
-	return file_proto_events_proto_rawDescGZIP(), []int{3}
+	return file_proto_events_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *RecordReply) GetMessage() string {
@@ -335,7 +483,7 @@ type StopRequest struct {
 func (x *StopRequest) Reset() {
 	*x = StopRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_events_proto_msgTypes[4]
+		mi := &file_proto_events_proto_msgTypes[5]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -348,7 +496,7 @@ func (x *StopRequest) String() string {
 func (*StopRequest) ProtoMessage() {}
 
 func (x *StopRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_events_proto_msgTypes[4]
+	mi := &file_proto_events_proto_msgTypes[5]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -361,7 +509,7 @@ func (x *StopRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use StopRequest.ProtoReflect.Descriptor instead.
 func (*StopRequest) Descriptor() ([]byte, []int) {
-	return file_proto_events_proto_rawDescGZIP(), []int{4}
+	return file_proto_events_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *StopRequest) GetId() string {
@@ -377,12 +525,20 @@ type StopReply struct {
 	unknownFields protoimpl.UnknownFields
 
 	Message string `protobuf:"bytes,1,opt,name=Message,proto3" json:"Message,omitempty"`
+	// Where the mixer stored the finished recording
+	StorageKey string `protobuf:"bytes,2,opt,name=storageKey,proto3" json:"storageKey,omitempty"`
+	// Length of the recording in milliseconds
+	DurationMs int64 `protobuf:"varint,3,opt,name=durationMs,proto3" json:"durationMs,omitempty"`
+	// Size of the stored file in bytes
+	SizeBytes int64 `protobuf:"varint,4,opt,name=sizeBytes,proto3" json:"sizeBytes,omitempty"`
+	// Audio format of the stored file, such as wav or ogg
+	Format string `protobuf:"bytes,5,opt,name=format,proto3" json:"format,omitempty"`
 }
 
 func (x *StopReply) Reset() {
 	*x = StopReply{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_events_proto_msgTypes[5]
+		mi := &file_proto_events_proto_msgTypes[6]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -395,7 +551,7 @@ func (x *StopReply) String() string {
 func (*StopReply) ProtoMessage() {}
 
 func (x *StopReply) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_events_proto_msgTypes[5]
+	mi := &file_proto_events_proto_msgTypes[6]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -408,7 +564,7 @@ func (x *StopReply) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use StopReply.ProtoReflect.Descriptor instead.
 func (*StopReply) Descriptor() ([]byte, []int) {
-	return file_proto_events_proto_rawDescGZIP(), []int{5}
+	return file_proto_events_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *StopReply) GetMessage() string {
@@ -418,56 +574,121 @@ func (x *StopReply) GetMessage() string {
 	return ""
 }
 
+func (x *StopReply) GetStorageKey() string {
+	if x != nil {
+		return x.StorageKey
+	}
+	return ""
+}
+
+func (x *StopReply) GetDurationMs() int64 {
+	if x != nil {
+		return x.DurationMs
+	}
+	return 0
+}
+
+func (x *StopReply) GetSizeBytes() int64 {
+	if x != nil {
+		return x.SizeBytes
+	}
+	return 0
+}
+
+func (x *StopReply) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
 var File_proto_events_proto protoreflect.FileDescriptor
 
 var file_proto_events_proto_rawDesc = []byte{
 	0x0a, 0x12, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x2e, 0x70,
-	0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x22, 0xe0, 0x01, 0x0a,
-	0x05, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x72, 0x65, 0x63, 0x6f, 0x72, 0x64,
-	0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x72, 0x65, 0x63, 0x6f, 0x72, 0x64,
-	0x49, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x65, 0x76, 0x74, 0x49, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28,
-	0x09, 0x52, 0x05, 0x65, 0x76, 0x74, 0x49, 0x64, 0x12, 0x25, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65,
-	0x18, 0x03, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x11, 0x2e, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x2e,
-	0x45, 0x76, 0x65, 0x6e, 0x74, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12,
-	0x1a, 0x0a, 0x08, 0x61, 0x73, 0x73, 0x65, 0x74, 0x55, 0x72, 0x6c, 0x18, 0x04, 0x20, 0x01, 0x28,
-	0x09, 0x52, 0x08, 0x61, 0x73, 0x73, 0x65, 0x74, 0x55, 0x72, 0x6c, 0x12, 0x12, 0x0a, 0x04, 0x6c,
-	0x6f, 0x6f, 0x70, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x04, 0x6c, 0x6f, 0x6f, 0x70, 0x12,
-	0x24, 0x0a, 0x0d, 0x76, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x44, 0x65, 0x6c, 0x74, 0x61, 0x44, 0x62,
-	0x18, 0x06, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0d, 0x76, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x44, 0x65,
-	0x6c, 0x74, 0x61, 0x44, 0x62, 0x12, 0x28, 0x0a, 0x0f, 0x73, 0x65, 0x65, 0x6b, 0x50, 0x6f, 0x73,
-	0x69, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x65, 0x63, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0f,
-	0x73, 0x65, 0x65, 0x6b, 0x50, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x65, 0x63, 0x22,
-	0x26, 0x0a, 0x0a, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x18, 0x0a,
-	0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07,
-	0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x1f, 0x0a, 0x0d, 0x52, 0x65, 0x63, 0x6f, 0x72,
-	0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01,
-	0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x22, 0x27, 0x0a, 0x0b, 0x52, 0x65, 0x63, 0x6f,
-	0x72, 0x64, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61,
+	0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x1a, 0x1f, 0x67, 0x6f,
+	0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x99, 0x01,
+	0x0a, 0x0d, 0x54, 0x72, 0x61, 0x63, 0x6b, 0x53, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x12,
+	0x14, 0x0a, 0x05, 0x65, 0x76, 0x74, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05,
+	0x65, 0x76, 0x74, 0x49, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x61, 0x73, 0x73, 0x65, 0x74, 0x55, 0x72,
+	0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x61, 0x73, 0x73, 0x65, 0x74, 0x55, 0x72,
+	0x6c, 0x12, 0x12, 0x0a, 0x04, 0x6c, 0x6f, 0x6f, 0x70, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x04, 0x6c, 0x6f, 0x6f, 0x70, 0x12, 0x1a, 0x0a, 0x08, 0x76, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x44,
+	0x62, 0x18, 0x04, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x76, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x44,
+	0x62, 0x12, 0x26, 0x0a, 0x0e, 0x73, 0x65, 0x65, 0x6b, 0x50, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x6f,
+	0x6e, 0x4d, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x73, 0x65, 0x65, 0x6b, 0x50,
+	0x6f, 0x73, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x4d, 0x73, 0x22, 0xed, 0x03, 0x0a, 0x05, 0x45, 0x76,
+	0x65, 0x6e, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x72, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x49, 0x64, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x72, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x49, 0x64, 0x12,
+	0x14, 0x0a, 0x05, 0x65, 0x76, 0x74, 0x49, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05,
+	0x65, 0x76, 0x74, 0x49, 0x64, 0x12, 0x25, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x0e, 0x32, 0x11, 0x2e, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x2e, 0x45, 0x76, 0x65,
+	0x6e, 0x74, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x1a, 0x0a, 0x08,
+	0x61, 0x73, 0x73, 0x65, 0x74, 0x55, 0x72, 0x6c, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08,
+	0x61, 0x73, 0x73, 0x65, 0x74, 0x55, 0x72, 0x6c, 0x12, 0x12, 0x0a, 0x04, 0x6c, 0x6f, 0x6f, 0x70,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x04, 0x6c, 0x6f, 0x6f, 0x70, 0x12, 0x24, 0x0a, 0x0d,
+	0x76, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x44, 0x65, 0x6c, 0x74, 0x61, 0x44, 0x62, 0x18, 0x06, 0x20,
+	0x01, 0x28, 0x01, 0x52, 0x0d, 0x76, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x44, 0x65, 0x6c, 0x74, 0x61,
+	0x44, 0x62, 0x12, 0x28, 0x0a, 0x0f, 0x73, 0x65, 0x65, 0x6b, 0x50, 0x6f, 0x73, 0x69, 0x74, 0x69,
+	0x6f, 0x6e, 0x53, 0x65, 0x63, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0f, 0x73, 0x65, 0x65,
+	0x6b, 0x50, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x65, 0x63, 0x12, 0x38, 0x0a, 0x09,
+	0x73, 0x74, 0x61, 0x74, 0x65, 0x44, 0x61, 0x74, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x73, 0x74, 0x61,
+	0x74, 0x65, 0x44, 0x61, 0x74, 0x65, 0x12, 0x3a, 0x0a, 0x0a, 0x72, 0x65, 0x63, 0x65, 0x69, 0x76,
+	0x65, 0x64, 0x41, 0x74, 0x18, 0x09, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d,
+	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x0a, 0x72, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x64,
+	0x41, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x65, 0x71, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x03, 0x73, 0x65, 0x71, 0x12, 0x26, 0x0a, 0x0e, 0x73, 0x65, 0x65, 0x6b, 0x50, 0x6f, 0x73, 0x69,
+	0x74, 0x69, 0x6f, 0x6e, 0x4d, 0x73, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x73, 0x65,
+	0x65, 0x6b, 0x50, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x4d, 0x73, 0x12, 0x1f, 0x0a, 0x08,
+	0x76, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x44, 0x62, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x01, 0x48, 0x00,
+	0x52, 0x08, 0x76, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x44, 0x62, 0x88, 0x01, 0x01, 0x12, 0x2d, 0x0a,
+	0x06, 0x74, 0x72, 0x61, 0x63, 0x6b, 0x73, 0x18, 0x0d, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x15, 0x2e,
+	0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x2e, 0x54, 0x72, 0x61, 0x63, 0x6b, 0x53, 0x6e, 0x61, 0x70,
+	0x73, 0x68, 0x6f, 0x74, 0x52, 0x06, 0x74, 0x72, 0x61, 0x63, 0x6b, 0x73, 0x42, 0x0b, 0x0a, 0x09,
+	0x5f, 0x76, 0x6f, 0x6c, 0x75, 0x6d, 0x65, 0x44, 0x62, 0x22, 0x26, 0x0a, 0x0a, 0x45, 0x76, 0x65,
+	0x6e, 0x74, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61,
 	0x67, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67,
-	0x65, 0x22, 0x1d, 0x0a, 0x0b, 0x53, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
-	0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64,
-	0x22, 0x25, 0x0a, 0x09, 0x53, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x18, 0x0a,
-	0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07,
-	0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2a, 0x68, 0x0a, 0x09, 0x45, 0x76, 0x65, 0x6e, 0x74,
-	0x54, 0x79, 0x70, 0x65, 0x12, 0x0f, 0x0a, 0x0b, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46,
-	0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x08, 0x0a, 0x04, 0x50, 0x4c, 0x41, 0x59, 0x10, 0x01, 0x12,
-	0x09, 0x0a, 0x05, 0x50, 0x41, 0x55, 0x53, 0x45, 0x10, 0x02, 0x12, 0x0a, 0x0a, 0x06, 0x52, 0x45,
-	0x53, 0x55, 0x4d, 0x45, 0x10, 0x03, 0x12, 0x08, 0x0a, 0x04, 0x53, 0x54, 0x4f, 0x50, 0x10, 0x04,
-	0x12, 0x08, 0x0a, 0x04, 0x53, 0x45, 0x45, 0x4b, 0x10, 0x05, 0x12, 0x0a, 0x0a, 0x06, 0x56, 0x4f,
-	0x4c, 0x55, 0x4d, 0x45, 0x10, 0x06, 0x12, 0x09, 0x0a, 0x05, 0x4f, 0x54, 0x48, 0x45, 0x52, 0x10,
-	0x07, 0x32, 0xa7, 0x01, 0x0a, 0x0b, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x53, 0x74, 0x72, 0x65, 0x61,
-	0x6d, 0x12, 0x33, 0x0a, 0x0c, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x45, 0x76, 0x65, 0x6e, 0x74,
-	0x73, 0x12, 0x0d, 0x2e, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x2e, 0x45, 0x76, 0x65, 0x6e, 0x74,
-	0x1a, 0x12, 0x2e, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x2e, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x52,
-	0x65, 0x70, 0x6c, 0x79, 0x28, 0x01, 0x12, 0x33, 0x0a, 0x05, 0x53, 0x74, 0x61, 0x72, 0x74, 0x12,
-	0x15, 0x2e, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x2e, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x52,
-	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x13, 0x2e, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x2e,
-	0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x2e, 0x0a, 0x04, 0x53,
-	0x74, 0x6f, 0x70, 0x12, 0x13, 0x2e, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x2e, 0x53, 0x74, 0x6f,
-	0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x11, 0x2e, 0x65, 0x76, 0x65, 0x6e, 0x74,
-	0x73, 0x2e, 0x53, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x42, 0x12, 0x5a, 0x10, 0x2e,
-	0x2f, 0x6a, 0x75, 0x6b, 0x65, 0x62, 0x6f, 0x78, 0x2d, 0x73, 0x79, 0x6e, 0x63, 0x65, 0x72, 0x62,
-	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x65, 0x22, 0x1f, 0x0a, 0x0d, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02,
+	0x69, 0x64, 0x22, 0x27, 0x0a, 0x0b, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x52, 0x65, 0x70, 0x6c,
+	0x79, 0x12, 0x18, 0x0a, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x1d, 0x0a, 0x0b, 0x53,
+	0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x22, 0x9b, 0x01, 0x0a, 0x09, 0x53,
+	0x74, 0x6f, 0x70, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x4d, 0x65, 0x73, 0x73,
+	0x61, 0x67, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61,
+	0x67, 0x65, 0x12, 0x1e, 0x0a, 0x0a, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x4b, 0x65, 0x79,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x4b,
+	0x65, 0x79, 0x12, 0x1e, 0x0a, 0x0a, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x4d, 0x73,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0a, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x4d, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x69, 0x7a, 0x65, 0x42, 0x79, 0x74, 0x65, 0x73, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x73, 0x69, 0x7a, 0x65, 0x42, 0x79, 0x74, 0x65, 0x73,
+	0x12, 0x16, 0x0a, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x2a, 0x7c, 0x0a, 0x09, 0x45, 0x76, 0x65, 0x6e,
+	0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0f, 0x0a, 0x0b, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49,
+	0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x08, 0x0a, 0x04, 0x50, 0x4c, 0x41, 0x59, 0x10, 0x01,
+	0x12, 0x09, 0x0a, 0x05, 0x50, 0x41, 0x55, 0x53, 0x45, 0x10, 0x02, 0x12, 0x0a, 0x0a, 0x06, 0x52,
+	0x45, 0x53, 0x55, 0x4d, 0x45, 0x10, 0x03, 0x12, 0x08, 0x0a, 0x04, 0x53, 0x54, 0x4f, 0x50, 0x10,
+	0x04, 0x12, 0x08, 0x0a, 0x04, 0x53, 0x45, 0x45, 0x4b, 0x10, 0x05, 0x12, 0x0a, 0x0a, 0x06, 0x56,
+	0x4f, 0x4c, 0x55, 0x4d, 0x45, 0x10, 0x06, 0x12, 0x09, 0x0a, 0x05, 0x4f, 0x54, 0x48, 0x45, 0x52,
+	0x10, 0x07, 0x12, 0x08, 0x0a, 0x04, 0x4c, 0x4f, 0x4f, 0x50, 0x10, 0x08, 0x12, 0x08, 0x0a, 0x04,
+	0x53, 0x59, 0x4e, 0x43, 0x10, 0x09, 0x32, 0xa7, 0x01, 0x0a, 0x0b, 0x45, 0x76, 0x65, 0x6e, 0x74,
+	0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x12, 0x33, 0x0a, 0x0c, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d,
+	0x45, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x12, 0x0d, 0x2e, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x2e,
+	0x45, 0x76, 0x65, 0x6e, 0x74, 0x1a, 0x12, 0x2e, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x2e, 0x45,
+	0x76, 0x65, 0x6e, 0x74, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x28, 0x01, 0x12, 0x33, 0x0a, 0x05, 0x53,
+	0x74, 0x61, 0x72, 0x74, 0x12, 0x15, 0x2e, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x2e, 0x52, 0x65,
+	0x63, 0x6f, 0x72, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x13, 0x2e, 0x65, 0x76,
+	0x65, 0x6e, 0x74, 0x73, 0x2e, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x52, 0x65, 0x70, 0x6c, 0x79,
+	0x12, 0x2e, 0x0a, 0x04, 0x53, 0x74, 0x6f, 0x70, 0x12, 0x13, 0x2e, 0x65, 0x76, 0x65, 0x6e, 0x74,
+	0x73, 0x2e, 0x53, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x11, 0x2e,
+	0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x2e, 0x53, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x70, 0x6c, 0x79,
+	0x42, 0x12, 0x5a, 0x10, 0x2e, 0x2f, 0x6a, 0x75, 0x6b, 0x65, 0x62, 0x6f, 0x78, 0x2d, 0x73, 0x79,
+	0x6e, 0x63, 0x65, 0x72, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -483,29 +704,34 @@ func file_proto_events_proto_rawDescGZIP() []byte {
 }
 
 var file_proto_events_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_proto_events_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_proto_events_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
 var file_proto_events_proto_goTypes = []interface{}{
-	(EventType)(0),        // 0: events.EventType
-	(*Event)(nil),         // 1: events.Event
-	(*EventReply)(nil),    // 2: events.EventReply
-	(*RecordRequest)(nil), // 3: events.RecordRequest
-	(*RecordReply)(nil),   // 4: events.RecordReply
-	(*StopRequest)(nil),   // 5: events.StopRequest
-	(*StopReply)(nil),     // 6: events.StopReply
+	(EventType)(0),                // 0: events.EventType
+	(*TrackSnapshot)(nil),         // 1: events.TrackSnapshot
+	(*Event)(nil),                 // 2: events.Event
+	(*EventReply)(nil),            // 3: events.EventReply
+	(*RecordRequest)(nil),         // 4: events.RecordRequest
+	(*RecordReply)(nil),           // 5: events.RecordReply
+	(*StopRequest)(nil),           // 6: events.StopRequest
+	(*StopReply)(nil),             // 7: events.StopReply
+	(*timestamppb.Timestamp)(nil), // 8: google.protobuf.Timestamp
 }
 var file_proto_events_proto_depIdxs = []int32{
 	0, // 0: events.Event.type:type_name -> events.EventType
-	1, // 1: events.EventStream.StreamEvents:input_type -> events.Event
-	3, // 2: events.EventStream.Start:input_type -> events.RecordRequest
-	5, // 3: events.EventStream.Stop:input_type -> events.StopRequest
-	2, // 4: events.EventStream.StreamEvents:output_type -> events.EventReply
-	4, // 5: events.EventStream.Start:output_type -> events.RecordReply
-	6, // 6: events.EventStream.Stop:output_type -> events.StopReply
-	4, // [4:7] is the sub-list for method output_type
-	1, // [1:4] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	8, // 1: events.Event.stateDate:type_name -> google.protobuf.Timestamp
+	8, // 2: events.Event.receivedAt:type_name -> google.protobuf.Timestamp
+	1, // 3: events.Event.tracks:type_name -> events.TrackSnapshot
+	2, // 4: events.EventStream.StreamEvents:input_type -> events.Event
+	4, // 5: events.EventStream.Start:input_type -> events.RecordRequest
+	6, // 6: events.EventStream.Stop:input_type -> events.StopRequest
+	3, // 7: events.EventStream.StreamEvents:output_type -> events.EventReply
+	5, // 8: events.EventStream.Start:output_type -> events.RecordReply
+	7, // 9: events.EventStream.Stop:output_type -> events.StopReply
+	7, // [7:10] is the sub-list for method output_type
+	4, // [4:7] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_proto_events_proto_init() }
@@ -515,7 +741,7 @@ func file_proto_events_proto_init() {
 	}
 	if !protoimpl.UnsafeEnabled {
 		file_proto_events_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Event); i {
+			switch v := v.(*TrackSnapshot); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -527,7 +753,7 @@ func file_proto_events_proto_init() {
 			}
 		}
 		file_proto_events_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*EventReply); i {
+			switch v := v.(*Event); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -539,7 +765,7 @@ func file_proto_events_proto_init() {
 			}
 		}
 		file_proto_events_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*RecordRequest); i {
+			switch v := v.(*EventReply); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -551,7 +777,7 @@ func file_proto_events_proto_init() {
 			}
 		}
 		file_proto_events_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*RecordReply); i {
+			switch v := v.(*RecordRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -563,7 +789,7 @@ func file_proto_events_proto_init() {
 			}
 		}
 		file_proto_events_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*StopRequest); i {
+			switch v := v.(*RecordReply); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -575,6 +801,18 @@ func file_proto_events_proto_init() {
 			}
 		}
 		file_proto_events_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StopRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_events_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*StopReply); i {
 			case 0:
 				return &v.state
@@ -587,13 +825,14 @@ func file_proto_events_proto_init() {
 			}
 		}
 	}
+	file_proto_events_proto_msgTypes[1].OneofWrappers = []interface{}{}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_proto_events_proto_rawDesc,
 			NumEnums:      1,
-			NumMessages:   6,
+			NumMessages:   7,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -0,0 +1,16 @@
+package jukebox_syncer
+
+// What the mixer answered when a record was stopped.
+// The answer to the record stream isn't part of the StopReply message, the client reads it from the stream
+type StopResult struct {
+	*StopReply
+	// Answer of the mixer once it read all the events of the record stream
+	StreamMessage string
+}
+
+func (x *StopResult) GetStreamMessage() string {
+	if x != nil {
+		return x.StreamMessage
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package jukebox_syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+)
+
+const autoStartKey = "auto-start-campaigns"
+
+// Record automatically the given campaigns : the first state received for one of them starts its record
+func WithAutoStart(campaigns ...string) Option {
+	return func(es *JukeboxSyncer) {
+		for _, id := range campaigns {
+			es.autoStart[id] = true
+		}
+	}
+}
+
+// Campaigns recorded automatically, sorted
+func (es *JukeboxSyncer) AutoStartCampaigns() []string {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	ids := make([]string, 0, len(es.autoStart))
+	for id := range es.autoStart {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Record a campaign automatically from now on
+func (es *JukeboxSyncer) AllowAutoStart(id string) error {
+	if id == "" {
+		return fmt.Errorf("campaign ID is empty")
+	}
+	es.mu.Lock()
+	es.autoStart[id] = true
+	es.mu.Unlock()
+	return es.saveAutoStart()
+}
+
+// Stop recording a campaign automatically. A record already started isn't stopped
+func (es *JukeboxSyncer) DisallowAutoStart(id string) error {
+	es.mu.Lock()
+	delete(es.autoStart, id)
+	es.mu.Unlock()
+	return es.saveAutoStart()
+}
+
+// Retrieve the worker of a record, starting the record first if its campaign is recorded automatically
+func (es *JukeboxSyncer) workerOrAutoStart(id string) (*recordWorker, bool) {
+	if w, ok := es.worker(id); ok {
+		return w, true
+	}
+	// Concurrent states of the same campaign must only start its record once,
+	// without holding back the states of the other campaigns
+	es.mu.Lock()
+	allowed := es.autoStart[id]
+	starting, pending := es.autoStarting[id]
+	if allowed && !pending {
+		starting = make(chan struct{})
+		es.autoStarting[id] = starting
+	}
+	es.mu.Unlock()
+	if !allowed {
+		return nil, false
+	}
+	if pending {
+		<-starting
+		return es.worker(id)
+	}
+	defer func() {
+		es.mu.Lock()
+		delete(es.autoStarting, id)
+		es.mu.Unlock()
+		close(starting)
+	}()
+	if w, ok := es.worker(id); ok {
+		return w, true
+	}
+	slog.Info(fmt.Sprintf("[Jukebox syncer] :: automatically starting record %s", id))
+	if err := es.Start(id); err != nil {
+		slog.Error(fmt.Sprintf("[Jukebox syncer] :: could not automatically start record %s : %s", id, err))
+		return nil, false
+	}
+	return es.worker(id)
+}
+
+// Save the campaigns recorded automatically, so that changes made at runtime survive a restart
+func (es *JukeboxSyncer) saveAutoStart() error {
+	if es.store == nil {
+		return nil
+	}
+	es.storeMu.Lock()
+	defer es.storeMu.Unlock()
+	value, err := json.Marshal(es.AutoStartCampaigns())
+	if err != nil {
+		return err
+	}
+	if err := es.store.Set(autoStartKey, value); err != nil {
+		return fmt.Errorf("could not save auto-started campaigns : %w", err)
+	}
+	return nil
+}
+
+// Add the campaigns recorded automatically saved by a previous process to the configured ones
+func (es *JukeboxSyncer) restoreAutoStart() error {
+	value, err := es.store.Get(autoStartKey)
+	if err != nil {
+		return fmt.Errorf("could not load auto-started campaigns : %w", err)
+	}
+	if value == nil {
+		return nil
+	}
+	var ids []string
+	if err := json.Unmarshal(value, &ids); err != nil {
+		return fmt.Errorf("could not parse auto-started campaigns : %w", err)
+	}
+	es.mu.Lock()
+	for _, id := range ids {
+		es.autoStart[id] = true
+	}
+	es.mu.Unlock()
+	return nil
+}
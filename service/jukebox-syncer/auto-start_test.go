@@ -0,0 +1,97 @@
+package jukebox_syncer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The first state of a whitelisted campaign starts its record
+func TestJukeboxSyncer_AutoStart(t *testing.T) {
+	m := &countingMixer{}
+	s := NewJukeboxSyncer(m, WithAutoStart("1"))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, s.Handle(&R20State{Rid: "1", Tracks: []R20Track{{Url: "a", Playing: true}}}))
+		}()
+	}
+	wg.Wait()
+	waitIdle(t, s, "1")
+	// Started only once, and the state was processed
+	assert.Equal(t, int32(1), m.starts.Load())
+	assert.Len(t, m.events, 1)
+}
+
+// A campaign slow to start doesn't hold back the others
+func TestJukeboxSyncer_AutoStartIndependent(t *testing.T) {
+	m := &countingMixer{blockedId: "1", block: make(chan struct{})}
+	s := NewJukeboxSyncer(m, WithAutoStart("1", "2"))
+	done := make(chan error)
+	go func() {
+		done <- s.Handle(&R20State{Rid: "1", Tracks: []R20Track{{Url: "a", Playing: true}}})
+	}()
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.autoStarting["1"] != nil
+	}, time.Second, time.Millisecond)
+	assert.NoError(t, s.Handle(&R20State{Rid: "2", Tracks: []R20Track{{Url: "a", Playing: true}}}))
+	close(m.block)
+	assert.NoError(t, <-done)
+	assert.Equal(t, int32(2), m.starts.Load())
+}
+
+// Other campaigns keep the strict behaviour
+func TestJukeboxSyncer_AutoStartNotWhitelisted(t *testing.T) {
+	s := NewJukeboxSyncer(&mockMixer{}, WithAutoStart("1"))
+	err := s.Handle(&R20State{Rid: "2", Tracks: []R20Track{{Url: "a", Playing: true}}})
+	assert.Error(t, err)
+}
+
+func TestJukeboxSyncer_AutoStartFailure(t *testing.T) {
+	s := NewJukeboxSyncer(&countingMixer{fail: true}, WithAutoStart("1"))
+	err := s.Handle(&R20State{Rid: "1", Tracks: []R20Track{{Url: "a", Playing: true}}})
+	assert.Error(t, err)
+	assert.Empty(t, s.records)
+}
+
+// Campaigns whitelisted at runtime must survive a restart
+func TestJukeboxSyncer_AutoStartApi(t *testing.T) {
+	store := &mockStore{}
+	s := NewJukeboxSyncer(&mockMixer{}, WithStateStore(store), WithAutoStart("1"))
+	assert.NoError(t, s.AllowAutoStart("2"))
+	assert.NoError(t, s.AllowAutoStart("3"))
+	assert.NoError(t, s.DisallowAutoStart("1"))
+	assert.Error(t, s.AllowAutoStart(""))
+	assert.Equal(t, []string{"2", "3"}, s.AutoStartCampaigns())
+
+	restarted := NewJukeboxSyncer(&mockMixer{}, WithStateStore(store), WithAutoStart("4"))
+	assert.NoError(t, restarted.Restore())
+	assert.Equal(t, []string{"2", "3", "4"}, restarted.AutoStartCampaigns())
+	assert.NoError(t, restarted.Handle(&R20State{Rid: "2", Tracks: []R20Track{{Url: "a", Playing: true}}}))
+}
+
+type countingMixer struct {
+	mockMixer
+	starts atomic.Int32
+	fail   bool
+	// Starts of this campaign wait for the channel to be closed
+	blockedId string
+	block     chan struct{}
+}
+
+func (m *countingMixer) Start(id string) error {
+	if id == m.blockedId {
+		<-m.block
+	}
+	if m.fail {
+		return assert.AnError
+	}
+	m.starts.Add(1)
+	return nil
+}
@@ -0,0 +1,197 @@
+package jukebox_syncer
+
+import (
+	"fmt"
+	"log/slog"
+	pb "roll20-audio-bouncer/proto"
+	"time"
+)
+
+// Latest version of a track whose changes are held back from the mixer
+type heldTrack struct {
+	// Nil if the track was removed from the jukebox
+	track *R20Track
+	// Date of the state the track comes from, and when it was received
+	stateDate  time.Time
+	receivedAt time.Time
+	// When the changes are sent to the mixer, unless the track changes again
+	deadline time.Time
+	// Set if the track is held because it is flapping, rather than debounced
+	flapping bool
+}
+
+// Hold the volume and seek changes of a track until it didn't change for the given window,
+// sending a single event with the final value. Dragging a Roll20 slider would send an event per intermediate value otherwise.
+// A zero window sends every change as soon as it is received
+func WithDebounceWindow(window time.Duration) Option {
+	return func(es *JukeboxSyncer) {
+		es.debounceWindow = window
+	}
+}
+
+// Consider a track played and stopped at least maxToggles times within the given period as flapping.
+// The changes of a flapping track are held until it is left alone for the period, and only its final state is sent.
+// A zero maxToggles disables the detection
+func WithFlappingLimit(maxToggles int, period time.Duration) Option {
+	return func(es *JukeboxSyncer) {
+		es.flappingToggles = maxToggles
+		es.flappingPeriod = period
+	}
+}
+
+// Check if an event only adjusts a playing track, and can be merged with the following ones
+func isDebounced(evt *pb.Event) bool {
+	return evt.Type == pb.EventType_VOLUME || evt.Type == pb.EventType_SEEK
+}
+
+// Check if an event starts or stops a track
+func isToggle(evt *pb.Event) bool {
+	switch evt.Type {
+	case pb.EventType_PLAY, pb.EventType_STOP, pb.EventType_PAUSE, pb.EventType_RESUME:
+		return true
+	default:
+		return false
+	}
+}
+
+// Filter out the events of the tracks that must be held back from the mixer.
+// Held tracks are reverted in the new state, which keeps describing what the mixer knows
+func (w *recordWorker) hold(events []*pb.Event, new *R20State) []*pb.Event {
+	now := time.Now()
+	byTrack := map[string][]*pb.Event{}
+	for _, evt := range events {
+		byTrack[evt.EvtId] = append(byTrack[evt.EvtId], evt)
+	}
+	// Tracks that went back to what the mixer knows, there is nothing left to send for them
+	for key := range w.held {
+		if _, ok := byTrack[key]; !ok {
+			delete(w.held, key)
+		}
+	}
+	held := map[string]bool{}
+	for key, trackEvents := range byTrack {
+		flapping := w.isFlapping(key, trackEvents, now)
+		debounced := w.syncer.debounceWindow > 0
+		for _, evt := range trackEvents {
+			debounced = debounced && isDebounced(evt)
+		}
+		if !flapping && !debounced {
+			// The delta is computed from what the mixer knows, so it includes the changes held so far
+			delete(w.held, key)
+			continue
+		}
+		h := &heldTrack{stateDate: new.Date, receivedAt: new.ReceivedAt, deadline: now.Add(w.syncer.debounceWindow), flapping: flapping}
+		if flapping {
+			h.deadline = now.Add(w.syncer.flappingPeriod)
+			if prev, ok := w.held[key]; !ok || !prev.flapping {
+				slog.Warn(fmt.Sprintf("[Jukebox syncer] :: track %s of record %s is flapping, holding its changes until it settles", key, w.id))
+			}
+		}
+		if t := findMatching(new, key); t != nil {
+			track := *t
+			h.track = &track
+		}
+		w.held[key] = h
+		held[key] = true
+		revertTrack(new, w.state, key)
+	}
+	if len(held) == 0 {
+		w.scheduleRelease()
+		return events
+	}
+	kept := make([]*pb.Event, 0, len(events))
+	for _, evt := range events {
+		if !held[evt.EvtId] {
+			kept = append(kept, evt)
+		}
+	}
+	w.scheduleRelease()
+	return kept
+}
+
+// Record the plays and stops of a track, and check if it toggled too many times lately
+func (w *recordWorker) isFlapping(key string, events []*pb.Event, now time.Time) bool {
+	if w.syncer.flappingToggles <= 0 {
+		return false
+	}
+	toggles := w.toggles[key][:0]
+	for _, t := range w.toggles[key] {
+		if now.Sub(t) < w.syncer.flappingPeriod {
+			toggles = append(toggles, t)
+		}
+	}
+	for _, evt := range events {
+		if isToggle(evt) {
+			toggles = append(toggles, now)
+		}
+	}
+	if len(toggles) == 0 {
+		delete(w.toggles, key)
+		return false
+	}
+	w.toggles[key] = toggles
+	return len(toggles) >= w.syncer.flappingToggles
+}
+
+// Make sure the held tracks will be released once their deadline passes
+func (w *recordWorker) scheduleRelease() {
+	if w.releaseTimer != nil {
+		w.releaseTimer.Stop()
+		w.releaseTimer = nil
+	}
+	var next time.Time
+	for _, h := range w.held {
+		if next.IsZero() || h.deadline.Before(next) {
+			next = h.deadline
+		}
+	}
+	if next.IsZero() {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(next), func() {
+		// The record may have stopped in the meantime, there is nothing left to release then
+		_ = w.enqueue(func() {
+			// A newer release has been scheduled since
+			if w.releaseTimer != timer || w.stopped {
+				return
+			}
+			w.releaseTimer = nil
+			w.release(false)
+		})
+	})
+	w.releaseTimer = timer
+}
+
+// Send the changes of the held tracks whose deadline passed, or of all of them
+func (w *recordWorker) release(all bool) {
+	if w.state == nil || len(w.held) == 0 {
+		return
+	}
+	now := time.Now()
+	target := *w.state
+	target.Tracks = append([]R20Track(nil), w.state.Tracks...)
+	stateDate, receivedAt := w.state.Date, w.state.ReceivedAt
+	for key, h := range w.held {
+		if !all && h.deadline.After(now) {
+			continue
+		}
+		delete(w.held, key)
+		revertTrack(&target, nil, key)
+		if h.track != nil {
+			target.Tracks = append(target.Tracks, *h.track)
+		}
+		if h.stateDate.After(stateDate) {
+			stateDate, receivedAt = h.stateDate, h.receivedAt
+		}
+	}
+	target.Date = stateDate
+	target.ReceivedAt = receivedAt
+	events, err := stateDelta(w.state, &target)
+	if err != nil {
+		slog.Error(fmt.Sprintf("[Jukebox syncer] :: while releasing held tracks of record %s : %s", w.id, err))
+		return
+	}
+	w.send(orderTransitions(events, w.state, &target), &target, stateDate, receivedAt)
+	w.scheduleRelease()
+}
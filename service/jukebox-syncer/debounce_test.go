@@ -0,0 +1,96 @@
+package jukebox_syncer
+
+import (
+	"github.com/stretchr/testify/assert"
+	pb "roll20-audio-bouncer/proto"
+	"testing"
+	"time"
+)
+
+func sentEvents(m *mockMixer) []*pb.Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*pb.Event(nil), m.events...)
+}
+
+// Dragging a volume slider sends a single event, with the final volume
+func TestJukeboxSyncer_DebounceVolume(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m, WithDebounceWindow(50*time.Millisecond))
+	assert.NoError(t, s.Start("1"))
+	refDate := time.Now()
+	for i, volume := range []float64{100, 90, 70, 50} {
+		assert.NoError(t, s.Handle(&R20State{Rid: "1", Date: refDate.Add(time.Duration(i) * time.Second), Tracks: []R20Track{{Url: "a", Playing: true, Volume: volume}}}))
+	}
+	stats, err := s.Stats("1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.HeldTracks)
+	assert.Eventually(t, func() bool { return len(sentEvents(m)) == 2 }, time.Second, 10*time.Millisecond)
+	evt := sentEvents(m)[1]
+	assert.Equal(t, pb.EventType_VOLUME, evt.Type)
+	assert.InDelta(t, computeVolumeDb(1, 0.5), evt.VolumeDeltaDb, 1e-9)
+	assert.Equal(t, refDate.Add(3*time.Second).UnixMilli(), evt.StateDate.AsTime().UnixMilli())
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, sentEvents(m), 2)
+}
+
+// A slider going back to its original value doesn't send anything
+func TestJukeboxSyncer_DebounceRevert(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m, WithDebounceWindow(50*time.Millisecond))
+	assert.NoError(t, s.Start("1"))
+	refDate := time.Now()
+	for i, volume := range []float64{100, 50, 100} {
+		assert.NoError(t, s.Handle(&R20State{Rid: "1", Date: refDate.Add(time.Duration(i) * time.Second), Tracks: []R20Track{{Url: "a", Playing: true, Volume: volume}}}))
+	}
+	time.Sleep(100 * time.Millisecond)
+	waitIdle(t, s, "1")
+	assert.Len(t, sentEvents(m), 1)
+}
+
+// Any other change sends the held changes of the track along with it
+func TestJukeboxSyncer_DebounceFlushedByTransition(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m, WithDebounceWindow(time.Hour))
+	assert.NoError(t, s.Start("1"))
+	refDate := time.Now()
+	assert.NoError(t, s.Handle(&R20State{Rid: "1", Date: refDate, Tracks: []R20Track{{Url: "a", Playing: true, Volume: 100}}}))
+	assert.NoError(t, s.Handle(&R20State{Rid: "1", Date: refDate.Add(time.Second), Tracks: []R20Track{{Url: "a", Playing: true, Volume: 50}}}))
+	assert.NoError(t, s.Handle(&R20State{Rid: "1", Date: refDate.Add(2 * time.Second), Tracks: []R20Track{{Url: "a", Playing: true, Volume: 50, Loop: true}}}))
+	waitIdle(t, s, "1")
+	events := sentEvents(m)
+	assert.Len(t, events, 3)
+	assert.Equal(t, pb.EventType_LOOP, events[1].Type)
+	assert.Equal(t, pb.EventType_VOLUME, events[2].Type)
+}
+
+// Stopping a record sends the held changes first
+func TestJukeboxSyncer_DebounceFlushedByStop(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m, WithDebounceWindow(time.Hour))
+	assert.NoError(t, s.Start("1"))
+	refDate := time.Now()
+	assert.NoError(t, s.Handle(&R20State{Rid: "1", Date: refDate, Tracks: []R20Track{{Url: "a", Playing: true, Volume: 100}}}))
+	assert.NoError(t, s.Handle(&R20State{Rid: "1", Date: refDate.Add(time.Second), Tracks: []R20Track{{Url: "a", Playing: true, Volume: 50}}}))
+	_, err := s.Stop("1")
+	assert.NoError(t, err)
+	events := sentEvents(m)
+	assert.Len(t, events, 2)
+	assert.Equal(t, pb.EventType_VOLUME, events[1].Type)
+}
+
+// A track toggled too often is held until it settles, and only its final state is sent
+func TestJukeboxSyncer_Flapping(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m, WithFlappingLimit(3, 50*time.Millisecond))
+	assert.NoError(t, s.Start("1"))
+	refDate := time.Now()
+	for i := 0; i < 6; i++ {
+		assert.NoError(t, s.Handle(&R20State{Rid: "1", Date: refDate.Add(time.Duration(i) * time.Second), Tracks: []R20Track{{Url: "a", Playing: i%2 == 0}}}))
+	}
+	waitIdle(t, s, "1")
+	// The first state, and the first two toggles
+	assert.Len(t, sentEvents(m), 3)
+	assert.Eventually(t, func() bool { return len(sentEvents(m)) == 4 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, pb.EventType_STOP, sentEvents(m)[3].Type)
+}
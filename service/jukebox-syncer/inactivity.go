@@ -0,0 +1,107 @@
+package jukebox_syncer
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Stop the records that haven't received any state or heartbeat for the given duration, as if /stop was called.
+// Stopping is the only way to end a recording, the mixer can't pause one.
+// A zero timeout keeps the records active until they are stopped
+func WithInactivityTimeout(timeout time.Duration) Option {
+	return func(es *JukeboxSyncer) {
+		es.inactivityTimeout = timeout
+	}
+}
+
+// Stop the records lasting longer than the given duration. A zero duration doesn't limit the records
+func WithMaxDuration(maxDuration time.Duration) Option {
+	return func(es *JukeboxSyncer) {
+		es.maxDuration = maxDuration
+	}
+}
+
+// Signal that a record is still active, even if its jukebox didn't change
+func (es *JukeboxSyncer) Heartbeat(id string) error {
+	w, ok := es.worker(id)
+	if !ok {
+		return fmt.Errorf("record %s hasn't started yet", id)
+	}
+	return w.enqueue(w.touch)
+}
+
+// Stop a record on its own, if it is still handled by the given worker
+func (es *JukeboxSyncer) expire(w *recordWorker, reason string) {
+	if current, ok := es.worker(w.id); !ok || current != w {
+		return
+	}
+	slog.Warn(fmt.Sprintf("[Jukebox syncer] :: stopping record %s : %s", w.id, reason))
+	if _, err := es.Stop(w.id); err != nil {
+		slog.Error(fmt.Sprintf("[Jukebox syncer] :: could not stop record %s : %s", w.id, err))
+	}
+}
+
+// Start watching the activity and duration of a recording. Must be called by the worker
+func (w *recordWorker) watch() {
+	es := w.syncer
+	if es.maxDuration > 0 && w.maxDurationTimer == nil {
+		w.maxDurationTimer = time.AfterFunc(es.maxDuration-timeNow().Sub(w.startedAt), w.checkMaxDuration)
+	}
+	w.touch()
+}
+
+// Stop the record if it lasted longer than the maximum duration
+func (w *recordWorker) checkMaxDuration() {
+	es := w.syncer
+	expired := false
+	err := w.do(func() error {
+		if w.stopped {
+			return nil
+		}
+		if remaining := es.maxDuration - timeNow().Sub(w.startedAt); remaining > 0 {
+			w.maxDurationTimer.Reset(remaining)
+			return nil
+		}
+		expired = true
+		return nil
+	})
+	if err == nil && expired {
+		es.expire(w, fmt.Sprintf("recording lasted longer than %s", es.maxDuration))
+	}
+}
+
+// Record some activity, postponing the inactivity timeout. Must be called by the worker
+func (w *recordWorker) touch() {
+	w.lastActivity = timeNow()
+	timeout := w.syncer.inactivityTimeout
+	if timeout <= 0 || w.stopped {
+		return
+	}
+	if w.inactivityTimer == nil {
+		w.inactivityTimer = time.AfterFunc(timeout, w.checkInactivity)
+	} else {
+		w.inactivityTimer.Reset(timeout)
+	}
+}
+
+// Stop the record if nothing happened since the inactivity timeout
+func (w *recordWorker) checkInactivity() {
+	es := w.syncer
+	inactive := false
+	err := w.do(func() error {
+		if w.stopped {
+			return nil
+		}
+		// Some activity may have happened while this check was queued
+		if remaining := es.inactivityTimeout - timeNow().Sub(w.lastActivity); remaining > 0 {
+			w.inactivityTimer.Reset(remaining)
+			return nil
+		}
+		inactive = true
+		return nil
+	})
+	if err == nil && inactive {
+		es.expire(w, fmt.Sprintf("nothing received for %s", es.inactivityTimeout))
+	}
+}
@@ -0,0 +1,88 @@
+package jukebox_syncer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func isStarted(s *JukeboxSyncer, id string) bool {
+	_, ok := s.worker(id)
+	return ok
+}
+
+func TestJukeboxSyncer_HeartbeatNotStarted(t *testing.T) {
+	s := NewJukeboxSyncer(&mockMixer{})
+	assert.Error(t, s.Heartbeat("1"))
+}
+
+// Run the inactivity check of a record at the given time, instead of waiting for its timer
+func checkInactivityAt(t *testing.T, s *JukeboxSyncer, id string, now time.Time) {
+	w, ok := s.worker(id)
+	assert.True(t, ok)
+	restore := mockNow(now)
+	defer restore()
+	w.checkInactivity()
+}
+
+// Run the duration check of a record at the given time, instead of waiting for its timer
+func checkMaxDurationAt(t *testing.T, s *JukeboxSyncer, id string, now time.Time) {
+	w, ok := s.worker(id)
+	assert.True(t, ok)
+	restore := mockNow(now)
+	defer restore()
+	w.checkMaxDuration()
+}
+
+// A record without any activity is stopped
+func TestJukeboxSyncer_InactivityStop(t *testing.T) {
+	s := NewJukeboxSyncer(&mockMixer{}, WithInactivityTimeout(time.Hour))
+	refDate := time.Now()
+	assert.NoError(t, s.Start("1"))
+	assert.NoError(t, s.Handle(&R20State{Rid: "1", Tracks: []R20Track{{Url: "a", Playing: true}}}))
+	waitIdle(t, s, "1")
+	checkInactivityAt(t, s, "1", refDate.Add(2*time.Hour))
+	assert.False(t, isStarted(s, "1"))
+}
+
+// Heartbeats keep a record active, even if its jukebox doesn't change
+func TestJukeboxSyncer_Heartbeat(t *testing.T) {
+	s := NewJukeboxSyncer(&mockMixer{}, WithInactivityTimeout(time.Hour))
+	refDate := time.Now()
+	assert.NoError(t, s.Start("1"))
+	restore := mockNow(refDate.Add(50 * time.Minute))
+	assert.NoError(t, s.Heartbeat("1"))
+	waitIdle(t, s, "1")
+	restore()
+	checkInactivityAt(t, s, "1", refDate.Add(90*time.Minute))
+	assert.True(t, isStarted(s, "1"))
+	checkInactivityAt(t, s, "1", refDate.Add(2*time.Hour))
+	assert.False(t, isStarted(s, "1"))
+}
+
+// Recordings can't last forever, even if they are active
+func TestJukeboxSyncer_MaxDuration(t *testing.T) {
+	s := NewJukeboxSyncer(&mockMixer{}, WithMaxDuration(50*time.Millisecond))
+	assert.NoError(t, s.Start("1"))
+	assert.Eventually(t, func() bool {
+		_ = s.Heartbeat("1")
+		return !isStarted(s, "1")
+	}, time.Second, 10*time.Millisecond)
+}
+
+// The recording duration must be kept across restarts
+func TestJukeboxSyncer_MaxDurationRestored(t *testing.T) {
+	store := &mockStore{}
+	refDate := time.Now()
+	s := NewJukeboxSyncer(&mockMixer{}, WithStateStore(store))
+	restore := mockNow(refDate)
+	assert.NoError(t, s.Start("1"))
+	restore()
+
+	restarted := NewJukeboxSyncer(&mockMixer{}, WithStateStore(store), WithMaxDuration(time.Hour))
+	assert.NoError(t, restarted.Restore())
+	checkMaxDurationAt(t, restarted, "1", refDate.Add(50*time.Minute))
+	assert.True(t, isStarted(restarted, "1"))
+	checkMaxDurationAt(t, restarted, "1", refDate.Add(2*time.Hour))
+	assert.False(t, isStarted(restarted, "1"))
+}
@@ -0,0 +1,50 @@
+package jukebox_syncer
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"roll20-audio-bouncer/internal/journal"
+	pb "roll20-audio-bouncer/proto"
+	"time"
+)
+
+// Reason journaled for the events that aren't sent
+var errSkipped = errors.New("skipped, a previous event of the track wasn't delivered")
+
+// Journal a call made to the mixer. Any error is non-fatal, the journal is only meant for troubleshooting
+func (es *JukeboxSyncer) journalEntry(id string, entry journal.Entry, callErr error) {
+	if es.journal == nil {
+		return
+	}
+	entry.Date = time.Now()
+	if callErr != nil {
+		entry.Error = callErr.Error()
+	}
+	if err := es.journal.Append(id, entry); err != nil {
+		slog.Warn(fmt.Sprintf("[Jukebox syncer] :: could not journal %s of record %s : %s", entry.Kind, id, err))
+	}
+}
+
+// Title of the track an event is about, looked up in the given states
+func trackTitle(evt *pb.Event, states ...*R20State) string {
+	for _, state := range states {
+		if state == nil {
+			continue
+		}
+		if t := findMatching(state, evt.EvtId); t != nil {
+			return t.Title
+		}
+	}
+	return ""
+}
+
+// Let the journal of a stopped record expire
+func (es *JukeboxSyncer) closeJournal(id string) {
+	if es.journal == nil {
+		return
+	}
+	if err := es.journal.Close(id); err != nil {
+		slog.Warn(fmt.Sprintf("[Jukebox syncer] :: could not close journal of record %s : %s", id, err))
+	}
+}
@@ -0,0 +1,103 @@
+package jukebox_syncer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"roll20-audio-bouncer/internal/journal"
+	pb "roll20-audio-bouncer/proto"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Every call made to the mixer must be journaled in order, with its result
+func TestJukeboxSyncer_Journal(t *testing.T) {
+	j := &mockJournal{}
+	s := NewJukeboxSyncer(&failingMixer{failures: 1}, WithJournal(j))
+	assert.NoError(t, s.Start("1"))
+	err := s.Handle(&R20State{Rid: "1", Tracks: []R20Track{{Url: "a", Title: "Tavern", Playing: true}}})
+	assert.NoError(t, err)
+	waitIdle(t, s, "1")
+	_, err = s.Stop("1")
+	assert.NoError(t, err)
+
+	entries := j.entries["1"]
+	assert.Len(t, entries, 3)
+	assert.Equal(t, journal.KindStart, entries[0].Kind)
+	assert.Empty(t, entries[0].Error)
+	assert.Equal(t, journal.KindEvent, entries[1].Kind)
+	assert.Equal(t, "a", entries[1].Event.EvtId)
+	assert.Equal(t, "Tavern", entries[1].Title)
+	assert.Equal(t, "Test", entries[1].Error)
+	assert.Equal(t, journal.KindStop, entries[2].Kind)
+	assert.Equal(t, "Test", entries[2].Message)
+	assert.True(t, j.closed["1"])
+}
+
+// Starting a running record again, such as an automatic start followed by a manual one, doesn't begin a new recording
+func TestJukeboxSyncer_JournalRestart(t *testing.T) {
+	j := &mockJournal{}
+	s := NewJukeboxSyncer(&mockMixer{}, WithJournal(j))
+	assert.NoError(t, s.Start("1"))
+	err := s.Handle(&R20State{Rid: "1", Tracks: []R20Track{{Url: "a", Playing: true}}})
+	assert.NoError(t, err)
+	waitIdle(t, s, "1")
+	assert.NoError(t, s.Start("1"))
+
+	entries := j.entries["1"]
+	assert.Len(t, entries, 2)
+	assert.Equal(t, journal.KindStart, entries[0].Kind)
+	assert.Equal(t, pb.EventType_PLAY, entries[1].Event.Type)
+	// The events of the recording are all replayed
+	replayed, err := journal.Replay(entries, "2", &mockMixer{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, replayed.Events)
+}
+
+// Events skipped after a failed delivery are journaled too, with the reason
+func TestJukeboxSyncer_JournalSkipped(t *testing.T) {
+	j := &mockJournal{}
+	s := NewJukeboxSyncer(&failingMixer{}, WithJournal(j))
+	assert.NoError(t, s.Start("1"))
+	refDate := time.Now()
+	err := s.Handle(&R20State{Rid: "1", Date: refDate, Tracks: []R20Track{{Url: "a", Volume: 50}}})
+	assert.NoError(t, err)
+	waitIdle(t, s, "1")
+	s.mixer.(*failingMixer).failures = 1
+	err = s.Handle(&R20State{Rid: "1", Date: refDate.Add(time.Second), Tracks: []R20Track{{Url: "a", Playing: true, Volume: 100}}})
+	assert.NoError(t, err)
+	waitIdle(t, s, "1")
+
+	entries := j.entries["1"]
+	assert.Len(t, entries, 3)
+	assert.Equal(t, pb.EventType_PLAY, entries[1].Event.Type)
+	assert.Equal(t, "Test", entries[1].Error)
+	assert.Equal(t, pb.EventType_VOLUME, entries[2].Event.Type)
+	assert.True(t, entries[2].Skipped)
+	assert.Equal(t, errSkipped.Error(), entries[2].Error)
+}
+
+type mockJournal struct {
+	entries map[string][]journal.Entry
+	closed  map[string]bool
+	mu      sync.Mutex
+}
+
+func (mj *mockJournal) Append(id string, entry journal.Entry) error {
+	mj.mu.Lock()
+	defer mj.mu.Unlock()
+	if mj.entries == nil {
+		mj.entries = map[string][]journal.Entry{}
+	}
+	mj.entries[id] = append(mj.entries[id], entry)
+	return nil
+}
+
+func (mj *mockJournal) Close(id string) error {
+	mj.mu.Lock()
+	defer mj.mu.Unlock()
+	if mj.closed == nil {
+		mj.closed = map[string]bool{}
+	}
+	mj.closed[id] = true
+	return nil
+}
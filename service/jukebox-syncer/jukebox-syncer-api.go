@@ -1,6 +1,7 @@
 package jukebox_syncer
 
 import (
+	"roll20-audio-bouncer/internal/journal"
 	pb "roll20-audio-bouncer/proto"
 	"time"
 )
@@ -15,13 +16,85 @@ type R20Track struct {
 	// Roll20 uses an identifier to check for seek position changes
 	Progress float64 `json:"progress" binding:"required"`
 	Duration string  `json:"duration" binding:"required"`
+	// Roll20 identifier of the track, and of the playlist containing it.
+	// These are optional, the URL is used to identify the track if missing
+	TrackId    string `json:"trackId,omitempty"`
+	PlaylistId string `json:"playlistId,omitempty"`
+	// When the state this track was last updated from was received
+	LastUpdate time.Time `json:"-"`
+	// Amplitude of the track, once its volume went through the volume curve of the campaign
+	amplitude    float64
+	hasAmplitude bool
+}
+
+// Playback mode of a Roll20 playlist
+type PlaylistMode string
+
+const (
+	// Play a single track of the playlist
+	PlaylistModeSingle PlaylistMode = "single"
+	// Play each track of the playlist in order
+	PlaylistModePlayThrough PlaylistMode = "playthrough"
+	// Play each track of the playlist in a random order
+	PlaylistModeShuffle PlaylistMode = "shuffle"
+	// Play all the tracks of the playlist at the same time
+	PlaylistModeTogether PlaylistMode = "together"
+	// Play each track of the playlist in order, starting over once the last one ends
+	PlaylistModeLoop PlaylistMode = "loop"
+)
+
+type R20Playlist struct {
+	Id    string       `json:"id" binding:"required"`
+	Title string       `json:"title"`
+	Mode  PlaylistMode `json:"mode"`
 }
 
 type R20State struct {
 	Uid    string     `json:"uId" binding:"required"`
 	Tracks []R20Track `json:"tracks" binding:"required"`
-	Rid    string     `json:"rId" binding:"required"`
-	Date   time.Time  `json:"date" binding:"required"`
+	// Playlists the tracks belong to. Optional, as older listeners don't send them
+	Playlists []R20Playlist `json:"playlists,omitempty"`
+	Rid       string        `json:"rId" binding:"required"`
+	Date      time.Time     `json:"date" binding:"required"`
+	// Optional monotonic sequence number set by the listener, used to order its states
+	Seq uint64 `json:"seq,omitempty"`
+	// Master volume of the jukebox, from 0 to 100. Optional, as older listeners don't send it
+	MasterVolume *float64 `json:"masterVolume,omitempty"`
+	// When the state was received by the syncer
+	ReceivedAt time.Time `json:"-"`
+	// Optional, called once the record worker is done with the state: with nil if it was applied,
+	// or with the reason it was rejected. Not called if Handle returns an error
+	Outcome func(err error) `json:"-"`
+}
+
+// Report what became of a state
+func (s *R20State) done(err error) {
+	if s.Outcome != nil {
+		s.Outcome(err)
+	}
+}
+
+// Ingestion statistics of a record
+type RecordStats struct {
+	// States waiting in the reorder buffer
+	BufferedStates int `json:"bufferedStates"`
+	// States received after the reorder window expired, and thus dropped
+	LateStates int `json:"lateStates"`
+	// Tracks whose changes are held back from the mixer, either debounced or flapping
+	HeldTracks int `json:"heldTracks"`
+}
+
+// What the mixer produced for a finished recording
+type RecordOutput struct {
+	RecordId  string    `json:"recordId"`
+	StoppedAt time.Time `json:"stoppedAt"`
+	// Where the mixer stored the recording
+	StorageKey string `json:"storageKey"`
+	DurationMs int64  `json:"durationMs"`
+	SizeBytes  int64  `json:"sizeBytes"`
+	Format     string `json:"format"`
+	// Free form message of the mixer
+	Message string `json:"message,omitempty"`
 }
 
 // Required payload to start or stop a recording
@@ -32,6 +105,21 @@ type RecPayload struct {
 // Backend API
 type MixerAPI interface {
 	Start(id string) error
-	Stop(id string) error
+	Stop(id string) (*pb.StopResult, error)
 	Send(evt *pb.Event) error
 }
+
+// Key/value storage, used to persist the syncer state across restarts
+type StateStore interface {
+	// Retrieve the value of a key, nil if the key doesn't exist
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+	Delete(key string) error
+}
+
+// Keeps track of every call made to the mixer for each record, and of its result
+type Journal interface {
+	Append(id string, entry journal.Entry) error
+	// The record stopped, its journal is only kept for a while
+	Close(id string) error
+}
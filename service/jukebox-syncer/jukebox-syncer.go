@@ -2,84 +2,195 @@ package jukebox_syncer
 
 import (
 	"fmt"
-	"log/slog"
+	"roll20-audio-bouncer/internal/journal"
 	pb "roll20-audio-bouncer/proto"
 	"sync"
+	"time"
 )
 
 type JukeboxSyncer struct {
 	mixer MixerAPI
-	// Mapping of the last known state of a game to its ID
-	stateMap map[string]*R20State
-	// Which record have already started, by ID
-	startedMap map[string]bool
-	mu         sync.Mutex
+	// Workers of the started records, by record ID
+	records map[string]*recordWorker
+	// How long a state is held to wait for older states arriving late
+	reorderWindow time.Duration
+	// Where started records and their state are persisted, optional
+	store StateStore
+	// Serializes the saving of the started records
+	storeMu sync.Mutex
+	// Where the calls made to the mixer are journaled, optional
+	journal Journal
+	// Campaigns whose record is started by their first state
+	autoStart map[string]bool
+	// Automatic starts in progress, by campaign. The channel is closed once the start is done
+	autoStarting map[string]chan struct{}
+	// How long a record can stay without receiving anything, and what happens then
+	inactivityTimeout time.Duration
+	// Longest recording allowed
+	maxDuration time.Duration
+	// Maps the volume sliders of the campaigns to amplitudes, by default and by campaign
+	volumeCurve          VolumeCurve
+	campaignVolumeCurves map[string]VolumeCurve
+	// How long the volume and seek changes of a track are held to be merged
+	debounceWindow time.Duration
+	// Plays and stops within the period making a track flapping, disabled if zero
+	flappingToggles int
+	flappingPeriod  time.Duration
+	// What the mixer produced for the last recording of each record
+	outputs map[string]*RecordOutput
+	mu      sync.Mutex
 }
 
-func NewJukeboxSyncer(mixer MixerAPI) *JukeboxSyncer {
-	return &JukeboxSyncer{
-		mixer:      mixer,
-		stateMap:   map[string]*R20State{},
-		startedMap: map[string]bool{},
-		mu:         sync.Mutex{},
+// Optional configuration of the syncer
+type Option func(*JukeboxSyncer)
+
+// Hold incoming states for the given duration, to reorder them before computing the deltas.
+// A zero window applies every state as soon as it is received
+func WithReorderWindow(window time.Duration) Option {
+	return func(es *JukeboxSyncer) {
+		es.reorderWindow = window
 	}
 }
 
-func (es *JukeboxSyncer) Start(id string) error {
+// Persist the started records and their last known state in the given store,
+// allowing the syncer to resume its work after a restart
+func WithStateStore(store StateStore) Option {
+	return func(es *JukeboxSyncer) {
+		es.store = store
+	}
+}
+
+// Journal every call made to the mixer, with its result
+func WithJournal(journal Journal) Option {
+	return func(es *JukeboxSyncer) {
+		es.journal = journal
+	}
+}
+
+func NewJukeboxSyncer(mixer MixerAPI, opts ...Option) *JukeboxSyncer {
+	es := &JukeboxSyncer{
+		mixer:        mixer,
+		records:      map[string]*recordWorker{},
+		autoStart:    map[string]bool{},
+		autoStarting: map[string]chan struct{}{},
+		outputs:      map[string]*RecordOutput{},
+		mu:           sync.Mutex{},
+
+		campaignVolumeCurves: map[string]VolumeCurve{},
+	}
+	for _, opt := range opts {
+		opt(es)
+	}
+	return es
+}
+
+// Retrieve the worker of a started record
+func (es *JukeboxSyncer) worker(id string) (*recordWorker, bool) {
 	es.mu.Lock()
 	defer es.mu.Unlock()
+	w, ok := es.records[id]
+	return w, ok
+}
+
+func (es *JukeboxSyncer) Start(id string) error {
+	es.mu.Lock()
+	w, ok := es.records[id]
+	if !ok {
+		w = newRecordWorker(id, es)
+		es.records[id] = w
+	}
+	es.mu.Unlock()
 	// Send start signal to live audio mixer
-	err := es.mixer.Start(id)
+	err := w.do(func() error {
+		err := es.mixer.Start(id)
+		// Starting a running record again doesn't begin a new recording, and mustn't look like it in the journal
+		if err != nil || w.startedAt.IsZero() {
+			es.journalEntry(id, journal.Entry{Kind: journal.KindStart}, err)
+		}
+		if err == nil && w.startedAt.IsZero() {
+			w.startedAt = timeNow()
+			w.watch()
+			es.saveRecord(w)
+		}
+		return err
+	})
 	if err != nil {
+		if !ok {
+			es.removeWorker(id, w)
+		}
 		return err
 	}
-	es.startedMap[id] = true
+	es.saveStarted()
 	return nil
 }
 
+// Queue a new state to be processed by the worker of its record.
+// The record of a campaign recorded automatically is started first if needed.
+// Processing errors are only logged, as the caller doesn't wait for them
 func (es *JukeboxSyncer) Handle(new *R20State) error {
-	es.mu.Lock()
-	defer es.mu.Unlock()
 	if new == nil {
 		return fmt.Errorf("New state is nil")
 	}
-	if _, ok := es.startedMap[new.Rid]; !ok {
+	w, ok := es.workerOrAutoStart(new.Rid)
+	if !ok {
 		return fmt.Errorf("Attempted to send an event for a record that hasn't started yet")
 	}
-	oldState, ok := es.stateMap[new.Rid]
-	var events []*pb.Event
-	var err error
-	if !ok {
-		// This is the first ever state we're receiving
-		events, err = scanForPlay(new)
-	} else {
-		events, err = stateDelta(oldState, new)
+	if new.ReceivedAt.IsZero() {
+		new.ReceivedAt = timeNow()
 	}
-	if err != nil {
-		return err
+	return w.enqueue(func() { w.handle(new) })
+}
+
+// Retrieve the ingestion statistics of a started record
+func (es *JukeboxSyncer) Stats(id string) (*RecordStats, error) {
+	w, ok := es.worker(id)
+	if !ok {
+		return nil, fmt.Errorf("record %s hasn't started yet", id)
 	}
+	var stats *RecordStats
+	err := w.do(func() error {
+		stats = w.stats()
+		return nil
+	})
+	return stats, err
+}
 
-	for _, evt := range events {
-		err := es.mixer.Send(evt)
-		// Any error here is non-fatal
+// Stop a record, returning what the mixer produced. The output is kept, see Output
+func (es *JukeboxSyncer) Stop(id string) (*RecordOutput, error) {
+	w, ok := es.worker(id)
+	if !ok {
+		// Nothing to clean up, but the mixer may still know about this record
+		reply, err := es.mixer.Stop(id)
 		if err != nil {
-			slog.Warn(fmt.Sprintf("event with url %s error %s", evt.AssetUrl, err))
+			return nil, err
 		}
-
+		// Without a recording, the output of the last one is kept
+		if reply.GetStorageKey() == "" {
+			return makeOutput(id, reply), nil
+		}
+		return es.saveOutput(id, reply), nil
 	}
-	es.stateMap[new.Rid] = new
-	return nil
+	var reply *pb.StopResult
+	err := w.do(func() error {
+		var err error
+		reply, err = w.stop()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	es.removeWorker(id, w)
+	es.deleteRecord(id)
+	es.closeJournal(id)
+	return es.saveOutput(id, reply), nil
 }
 
-func (es *JukeboxSyncer) Stop(id string) error {
+// Forget about the worker of a record, if it wasn't replaced in the meantime
+func (es *JukeboxSyncer) removeWorker(id string, w *recordWorker) {
 	es.mu.Lock()
-	defer es.mu.Unlock()
-	// Send stop signal to live audio mixer, get the storage key and get it back to the message bus
-	err := es.mixer.Stop(id)
-	if err != nil {
-		return err
+	if es.records[id] == w {
+		delete(es.records, id)
 	}
-	delete(es.startedMap, id)
-	delete(es.stateMap, id)
-	return nil
+	es.mu.Unlock()
+	w.close()
 }
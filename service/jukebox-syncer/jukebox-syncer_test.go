@@ -1,9 +1,14 @@
 package jukebox_syncer
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	pb "roll20-audio-bouncer/proto"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestJukeboxSyncer_HandleStateIsNil(t *testing.T) {
@@ -14,7 +19,8 @@ func TestJukeboxSyncer_HandleStateIsNil(t *testing.T) {
 
 // Assert they're no state retention when a recording stops
 func TestJukeboxSyncer_StateDeletion(t *testing.T) {
-	s := NewJukeboxSyncer(&mockMixer{})
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m)
 	err := s.Start("1")
 	assert.NoError(t, err)
 	err = s.Handle(&R20State{
@@ -28,12 +34,10 @@ func TestJukeboxSyncer_StateDeletion(t *testing.T) {
 		},
 	})
 	assert.NoError(t, err)
-	err = s.Stop("1")
+	_, err = s.Stop("1")
 	assert.NoError(t, err)
 	err = s.Start("1")
 	assert.NoError(t, err)
-	// If state has been kept, this will throw an error
-	// as the user ID is different
 	err = s.Handle(&R20State{
 		Rid: "1",
 		Uid: "3",
@@ -45,8 +49,11 @@ func TestJukeboxSyncer_StateDeletion(t *testing.T) {
 		},
 	})
 	assert.NoError(t, err)
-
+	// If state has been kept, the track would already be playing
+	waitIdle(t, s, "1")
+	assert.Len(t, m.events, 2)
 }
+
 func TestJukeboxSyncer_HandleStateBeforeStartError(t *testing.T) {
 	s := NewJukeboxSyncer(&mockMixer{})
 	err := s.Handle(&R20State{
@@ -100,17 +107,273 @@ func TestJukeboxSyncer_HandleStateDelta(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+// Every event carries the date of its state, when it was received and a per-record sequence number
+func TestJukeboxSyncer_EventTiming(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m)
+	assert.NoError(t, s.Start("1"))
+	refDate := time.Now().Add(-time.Second)
+	err := s.Handle(&R20State{Rid: "1", Date: refDate, Tracks: []R20Track{{Url: "a", Playing: true}, {Url: "b", Playing: true}}})
+	assert.NoError(t, err)
+	err = s.Handle(&R20State{Rid: "1", Date: refDate.Add(time.Second), Tracks: []R20Track{{Url: "a", Playing: false}, {Url: "b", Playing: true}}})
+	assert.NoError(t, err)
+	waitIdle(t, s, "1")
+
+	assert.Len(t, m.events, 3)
+	for i, evt := range m.events {
+		assert.Equal(t, uint64(i+1), evt.Seq)
+		assert.NotNil(t, evt.ReceivedAt)
+		assert.False(t, evt.ReceivedAt.AsTime().Before(refDate))
+	}
+	assert.True(t, refDate.Equal(m.events[0].StateDate.AsTime()))
+	assert.True(t, refDate.Add(time.Second).Equal(m.events[2].StateDate.AsTime()))
+}
+
+// Two users sending the same state must only produce a single set of events
+func TestJukeboxSyncer_HandleMultipleUsers(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m)
+	err := s.Start("1")
+	assert.NoError(t, err)
+	refDate := time.Now()
+	for _, uid := range []string{"a", "b"} {
+		err = s.Handle(&R20State{
+			Rid:    "1",
+			Uid:    uid,
+			Date:   refDate,
+			Tracks: []R20Track{{Url: "a", Playing: true}},
+		})
+		assert.NoError(t, err)
+	}
+	waitIdle(t, s, "1")
+	assert.Len(t, m.events, 1)
+	// Then, the second user stops the track
+	err = s.Handle(&R20State{
+		Rid:    "1",
+		Uid:    "b",
+		Date:   refDate.Add(time.Second),
+		Tracks: []R20Track{{Url: "a", Playing: false}},
+	})
+	assert.NoError(t, err)
+	waitIdle(t, s, "1")
+	assert.Len(t, m.events, 2)
+	assert.True(t, m.events[1].Type == pb.EventType_STOP, "expected stop event")
+}
+
+// Out of order states must be applied in order once the window expires
+func TestJukeboxSyncer_HandleOutOfOrder(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m, WithReorderWindow(50*time.Millisecond))
+	err := s.Start("1")
+	assert.NoError(t, err)
+	refDate := time.Now()
+	err = s.Handle(&R20State{Rid: "1", Date: refDate.Add(time.Second), Tracks: []R20Track{{Url: "a", Playing: false}}})
+	assert.NoError(t, err)
+	err = s.Handle(&R20State{Rid: "1", Date: refDate, Tracks: []R20Track{{Url: "a", Playing: true}}})
+	assert.NoError(t, err)
+	stats, err := s.Stats("1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stats.BufferedStates)
+	assert.Eventually(t, func() bool {
+		stats, _ := s.Stats("1")
+		return stats.BufferedStates == 0
+	}, time.Second, 10*time.Millisecond)
+	_, err = s.Stop("1")
+	assert.NoError(t, err)
+	assert.Len(t, m.events, 2)
+	assert.True(t, m.events[0].Type == pb.EventType_PLAY, "expected play event")
+	assert.True(t, m.events[1].Type == pb.EventType_STOP, "expected stop event")
+}
+
+func TestJukeboxSyncer_HandleLateState(t *testing.T) {
+	s := NewJukeboxSyncer(&mockMixer{})
+	err := s.Start("1")
+	assert.NoError(t, err)
+	refDate := time.Now()
+	err = s.Handle(&R20State{Rid: "1", Date: refDate})
+	assert.NoError(t, err)
+	// Late states are counted, but the caller doesn't wait for the state to be processed
+	outcome := make(chan error, 1)
+	err = s.Handle(&R20State{Rid: "1", Date: refDate.Add(-time.Second), Outcome: func(err error) { outcome <- err }})
+	assert.NoError(t, err)
+	stats, err := s.Stats("1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.LateStates)
+	// The caller is still told the state was dropped
+	assert.Error(t, <-outcome)
+}
+
+// Applied and duplicate states are reported through their outcome
+func TestJukeboxSyncer_HandleOutcome(t *testing.T) {
+	s := NewJukeboxSyncer(&mockMixer{})
+	err := s.Start("1")
+	assert.NoError(t, err)
+	refDate := time.Now()
+	outcomes := make(chan error, 2)
+	tracks := []R20Track{{Url: "a", Playing: true}}
+	err = s.Handle(&R20State{Rid: "1", Uid: "a", Date: refDate, Tracks: tracks, Outcome: func(err error) { outcomes <- err }})
+	assert.NoError(t, err)
+	err = s.Handle(&R20State{Rid: "1", Uid: "b", Date: refDate.Add(time.Second), Tracks: tracks, Outcome: func(err error) { outcomes <- err }})
+	assert.NoError(t, err)
+	assert.NoError(t, <-outcomes)
+	assert.ErrorIs(t, <-outcomes, errDuplicate)
+}
+
+// Buffered states must be applied before the record stops
+func TestJukeboxSyncer_StopDrainsBuffer(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m, WithReorderWindow(time.Hour))
+	err := s.Start("1")
+	assert.NoError(t, err)
+	err = s.Handle(&R20State{Rid: "1", Tracks: []R20Track{{Url: "a", Playing: true}}})
+	assert.NoError(t, err)
+	waitIdle(t, s, "1")
+	assert.Len(t, m.events, 0)
+	_, err = s.Stop("1")
+	assert.NoError(t, err)
+	assert.Len(t, m.events, 1)
+	_, err = s.Stats("1")
+	assert.Error(t, err)
+}
+
+// A record stuck on a slow mixer must not block the others
+func TestJukeboxSyncer_RecordsAreIndependent(t *testing.T) {
+	m := &blockingMixer{blocked: "1", release: make(chan struct{})}
+	defer close(m.release)
+	s := NewJukeboxSyncer(m)
+	assert.NoError(t, s.Start("1"))
+	assert.NoError(t, s.Start("2"))
+	state := func(id string) *R20State {
+		return &R20State{Rid: id, Tracks: []R20Track{{Url: "a", Playing: true}}}
+	}
+	// The handler must not wait for the state to be processed
+	assert.NoError(t, s.Handle(state("1")))
+	assert.NoError(t, s.Handle(state("2")))
+	waitIdle(t, s, "2")
+	assert.Equal(t, int32(1), m.sent.Load())
+}
+
+// Events of a single record must be sent in the order the states were received
+func TestJukeboxSyncer_EventOrdering(t *testing.T) {
+	m := &mockMixer{latency: time.Millisecond}
+	s := NewJukeboxSyncer(m)
+	assert.NoError(t, s.Start("1"))
+	refDate := time.Now()
+	for i := 0; i < 20; i++ {
+		err := s.Handle(&R20State{Rid: "1", Date: refDate.Add(time.Duration(i) * time.Second), Tracks: []R20Track{{Url: "a", Playing: i%2 == 0}}})
+		assert.NoError(t, err)
+	}
+	_, err := s.Stop("1")
+	assert.NoError(t, err)
+	assert.Len(t, m.events, 20)
+	for i, evt := range m.events {
+		expected := pb.EventType_PLAY
+		if i%2 == 1 {
+			expected = pb.EventType_STOP
+		}
+		assert.Equal(t, expected, evt.Type)
+	}
+}
+
+// An event the mixer didn't receive must be sent again with the next state
+func TestJukeboxSyncer_FailedDeliveryIsRetried(t *testing.T) {
+	m := &failingMixer{failures: 1}
+	s := NewJukeboxSyncer(m)
+	assert.NoError(t, s.Start("1"))
+	refDate := time.Now()
+	for i := 0; i < 2; i++ {
+		err := s.Handle(&R20State{Rid: "1", Date: refDate.Add(time.Duration(i) * time.Second), Tracks: []R20Track{{Url: "a", Playing: true, Volume: 50}, {Url: "b", Playing: true}}})
+		assert.NoError(t, err)
+	}
+	waitIdle(t, s, "1")
+	// a failed on the first state, b is only sent once
+	assert.Len(t, m.events, 2)
+	assert.Equal(t, "b", m.events[0].EvtId)
+	assert.Equal(t, "a", m.events[1].EvtId)
+	assert.True(t, m.events[1].Type == pb.EventType_PLAY, "expected play event")
+}
+
+// Send toggling states to a number of records, with a mixer taking 1ms per event.
+// With per-record workers, the time per state should decrease as the number of records increases
+func benchmarkRecords(b *testing.B, records int) {
+	m := &mockMixer{latency: time.Millisecond}
+	s := NewJukeboxSyncer(m)
+	for r := 0; r < records; r++ {
+		assert.NoError(b, s.Start(strconv.Itoa(r)))
+	}
+	refDate := time.Now()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rId := strconv.Itoa(i % records)
+		err := s.Handle(&R20State{Rid: rId, Date: refDate.Add(time.Duration(i) * time.Millisecond), Tracks: []R20Track{{Url: "a", Playing: (i/records)%2 == 0}}})
+		assert.NoError(b, err)
+	}
+	for r := 0; r < records; r++ {
+		waitIdle(b, s, strconv.Itoa(r))
+	}
+}
+
+func BenchmarkJukeboxSyncer_1Record(b *testing.B)   { benchmarkRecords(b, 1) }
+func BenchmarkJukeboxSyncer_8Records(b *testing.B)  { benchmarkRecords(b, 8) }
+func BenchmarkJukeboxSyncer_64Records(b *testing.B) { benchmarkRecords(b, 64) }
+
+// Wait for all the tasks queued for a record to be processed
+func waitIdle(t testing.TB, s *JukeboxSyncer, id string) {
+	_, err := s.Stats(id)
+	assert.NoError(t, err)
+}
+
 type mockMixer struct {
 	MixerAPI
+	// All events sent to the mixer
+	events []*pb.Event
+	// Simulated network latency
+	latency time.Duration
+	mu      sync.Mutex
 }
 
 func (m *mockMixer) Send(evt *pb.Event) error {
+	time.Sleep(m.latency)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, evt)
 	return nil
 }
 func (m *mockMixer) Start(id string) error {
 	return nil
 }
 
-func (m *mockMixer) Stop(id string) error {
+func (m *mockMixer) Stop(id string) (*pb.StopResult, error) {
+	return &pb.StopResult{StopReply: &pb.StopReply{StorageKey: "records/" + id + ".ogg", Format: "ogg"}, StreamMessage: "Test"}, nil
+}
+
+// Mixer blocking every event of a record until released
+type blockingMixer struct {
+	mockMixer
+	blocked string
+	release chan struct{}
+	// Number of events sent
+	sent atomic.Int32
+}
+
+func (m *blockingMixer) Send(evt *pb.Event) error {
+	if evt.RecordId == m.blocked {
+		<-m.release
+	}
+	m.sent.Add(1)
 	return nil
 }
+
+// Mixer failing the first events it receives
+type failingMixer struct {
+	mockMixer
+	failures int
+}
+
+func (m *failingMixer) Send(evt *pb.Event) error {
+	if m.failures > 0 {
+		m.failures--
+		return fmt.Errorf("Test")
+	}
+	return m.mockMixer.Send(evt)
+}
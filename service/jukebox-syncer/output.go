@@ -0,0 +1,77 @@
+package jukebox_syncer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	pb "roll20-audio-bouncer/proto"
+	"time"
+)
+
+// The record never finished a recording
+var ErrNoOutput = errors.New("no finished recording")
+
+// Key of the output of the last recording of a record in the state store
+func outputKey(id string) string {
+	return "output-" + id
+}
+
+// What the mixer produced for a record, from its answer to the stop
+func makeOutput(id string, reply *pb.StopResult) *RecordOutput {
+	output := &RecordOutput{RecordId: id, StoppedAt: time.Now()}
+	if reply != nil {
+		output.StorageKey = reply.StorageKey
+		output.DurationMs = reply.DurationMs
+		output.SizeBytes = reply.SizeBytes
+		output.Format = reply.Format
+		output.Message = reply.Message
+	}
+	return output
+}
+
+// Keep what the mixer produced for a record, replacing the output of its previous recording.
+// Any error is non-fatal, the recording is over anyway
+func (es *JukeboxSyncer) saveOutput(id string, reply *pb.StopResult) *RecordOutput {
+	output := makeOutput(id, reply)
+	slog.Info(fmt.Sprintf("[Jukebox syncer] :: record %s stopped, stored as %s", id, output.StorageKey))
+	es.mu.Lock()
+	es.outputs[id] = output
+	es.mu.Unlock()
+	if es.store == nil {
+		return output
+	}
+	value, err := json.Marshal(output)
+	if err == nil {
+		err = es.store.Set(outputKey(id), value)
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("[Jukebox syncer] :: could not save output of record %s : %s", id, err))
+	}
+	return output
+}
+
+// Retrieve what the mixer produced for the last recording of a record
+func (es *JukeboxSyncer) Output(id string) (*RecordOutput, error) {
+	es.mu.Lock()
+	output, ok := es.outputs[id]
+	es.mu.Unlock()
+	if ok {
+		return output, nil
+	}
+	// The recording may have been stopped by a previous process
+	if es.store != nil {
+		value, err := es.store.Get(outputKey(id))
+		if err != nil {
+			return nil, fmt.Errorf("could not load output of record %s : %w", id, err)
+		}
+		if value != nil {
+			output = &RecordOutput{}
+			if err := json.Unmarshal(value, output); err != nil {
+				return nil, fmt.Errorf("could not parse output of record %s : %w", id, err)
+			}
+			return output, nil
+		}
+	}
+	return nil, fmt.Errorf("record %s : %w", id, ErrNoOutput)
+}
@@ -0,0 +1,75 @@
+package jukebox_syncer
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	pb "roll20-audio-bouncer/proto"
+	"testing"
+)
+
+func TestJukeboxSyncer_StopOutput(t *testing.T) {
+	s := NewJukeboxSyncer(&mockMixer{})
+	assert.NoError(t, s.Start("1"))
+	output, err := s.Stop("1")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", output.RecordId)
+	assert.Equal(t, "records/1.ogg", output.StorageKey)
+	assert.Equal(t, "ogg", output.Format)
+	assert.False(t, output.StoppedAt.IsZero())
+	kept, err := s.Output("1")
+	assert.NoError(t, err)
+	assert.Equal(t, output, kept)
+}
+
+// Stopping a record that isn't started doesn't replace the output of its last recording
+func TestJukeboxSyncer_StopNotStartedOutput(t *testing.T) {
+	m := &emptyStopMixer{}
+	s := NewJukeboxSyncer(m)
+	assert.NoError(t, s.Start("1"))
+	_, err := s.Stop("1")
+	assert.NoError(t, err)
+	m.empty = true
+	output, err := s.Stop("1")
+	assert.NoError(t, err)
+	assert.Empty(t, output.StorageKey)
+	kept, err := s.Output("1")
+	assert.NoError(t, err)
+	assert.Equal(t, "records/1.ogg", kept.StorageKey)
+}
+
+// Mixer that can answer a stop without any recording
+type emptyStopMixer struct {
+	mockMixer
+	empty bool
+}
+
+func (m *emptyStopMixer) Stop(id string) (*pb.StopResult, error) {
+	if m.empty {
+		return &pb.StopResult{StopReply: &pb.StopReply{}}, nil
+	}
+	return m.mockMixer.Stop(id)
+}
+
+func TestJukeboxSyncer_OutputUnknownRecord(t *testing.T) {
+	s := NewJukeboxSyncer(&mockMixer{})
+	assert.NoError(t, s.Start("1"))
+	_, err := s.Output("1")
+	assert.True(t, errors.Is(err, ErrNoOutput))
+}
+
+// The output of a record stopped by a previous process is found in the store
+func TestJukeboxSyncer_OutputPersisted(t *testing.T) {
+	store := &mockStore{}
+	s := NewJukeboxSyncer(&mockMixer{}, WithStateStore(store))
+	assert.NoError(t, s.Start("1"))
+	_, err := s.Stop("1")
+	assert.NoError(t, err)
+	assert.Contains(t, store.values, outputKey("1"))
+
+	restarted := NewJukeboxSyncer(&mockMixer{}, WithStateStore(store))
+	output, err := restarted.Output("1")
+	assert.NoError(t, err)
+	assert.Equal(t, "records/1.ogg", output.StorageKey)
+	_, err = restarted.Output("2")
+	assert.True(t, errors.Is(err, ErrNoOutput))
+}
@@ -0,0 +1,148 @@
+package jukebox_syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// Key of the list of started records in the state store
+const startedRecordsKey = "started-records"
+
+// Key of the last known state of a record in the state store
+func recordKey(id string) string {
+	return "record-" + id
+}
+
+// Persisted version of a record.
+// Track update dates aren't part of the JSON representation of a track, they are saved separately
+type persistedRecord struct {
+	State        *R20State            `json:"state,omitempty"`
+	TrackUpdates map[string]time.Time `json:"trackUpdates,omitempty"`
+	// Sequence number of the last event sent, so that it keeps increasing after a restart
+	Seq uint64 `json:"seq"`
+	// When the recording started
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// Save the list of started records. Any error is non-fatal, the syncer can still work without a store
+func (es *JukeboxSyncer) saveStarted() {
+	if es.store == nil {
+		return
+	}
+	// Two concurrent saves must not overwrite a newer list with an older one
+	es.storeMu.Lock()
+	defer es.storeMu.Unlock()
+	es.mu.Lock()
+	ids := make([]string, 0, len(es.records))
+	for id := range es.records {
+		ids = append(ids, id)
+	}
+	es.mu.Unlock()
+	sort.Strings(ids)
+	value, err := json.Marshal(ids)
+	if err == nil {
+		err = es.store.Set(startedRecordsKey, value)
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("[Jukebox syncer] :: could not save started records : %s", err))
+	}
+}
+
+// Save the last known state of a record. Must be called by its worker
+func (es *JukeboxSyncer) saveRecord(w *recordWorker) {
+	if es.store == nil {
+		return
+	}
+	id := w.id
+	record := persistedRecord{State: w.state, TrackUpdates: map[string]time.Time{}, Seq: w.seq, StartedAt: w.startedAt}
+	if record.State != nil {
+		for _, t := range record.State.Tracks {
+			record.TrackUpdates[trackKey(&t)] = t.LastUpdate
+		}
+	}
+	value, err := json.Marshal(record)
+	if err == nil {
+		err = es.store.Set(recordKey(id), value)
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("[Jukebox syncer] :: could not save state of record %s : %s", id, err))
+	}
+}
+
+// Forget everything about a record
+func (es *JukeboxSyncer) deleteRecord(id string) {
+	if es.store == nil {
+		return
+	}
+	if err := es.store.Delete(recordKey(id)); err != nil {
+		slog.Warn(fmt.Sprintf("[Jukebox syncer] :: could not delete state of record %s : %s", id, err))
+	}
+	es.saveStarted()
+}
+
+// Load the started records and their last known state back from the state store.
+// Meant to be called once at boot, before handling any state
+func (es *JukeboxSyncer) Restore() error {
+	if es.store == nil {
+		return nil
+	}
+	if err := es.restoreAutoStart(); err != nil {
+		return err
+	}
+	value, err := es.store.Get(startedRecordsKey)
+	if err != nil {
+		return fmt.Errorf("could not load started records : %w", err)
+	}
+	if value == nil {
+		return nil
+	}
+	var ids []string
+	if err := json.Unmarshal(value, &ids); err != nil {
+		return fmt.Errorf("could not parse started records : %w", err)
+	}
+	for _, id := range ids {
+		record, err := es.loadRecord(id)
+		if err != nil {
+			return err
+		}
+		w := newRecordWorker(id, es)
+		// The worker can't be processing anything yet
+		w.state, w.seq, w.startedAt = record.State, record.Seq, record.StartedAt
+		applyVolumeCurve(w.state, es.curveOf(id))
+		if w.startedAt.IsZero() {
+			w.startedAt = timeNow()
+		}
+		es.mu.Lock()
+		es.records[id] = w
+		es.mu.Unlock()
+		// Records get a new inactivity timeout, as we couldn't receive anything while restarting
+		_ = w.enqueue(w.watch)
+	}
+	slog.Info(fmt.Sprintf("[Jukebox syncer] :: restored %d started records", len(ids)))
+	return nil
+}
+
+// Load a persisted record, its state is nil if no state was received yet
+func (es *JukeboxSyncer) loadRecord(id string) (*persistedRecord, error) {
+	var record persistedRecord
+	value, err := es.store.Get(recordKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("could not load state of record %s : %w", id, err)
+	}
+	if value == nil {
+		return &record, nil
+	}
+	if err := json.Unmarshal(value, &record); err != nil {
+		return nil, fmt.Errorf("could not parse state of record %s : %w", id, err)
+	}
+	if record.State == nil {
+		return &record, nil
+	}
+	for i := range record.State.Tracks {
+		record.State.Tracks[i].LastUpdate = record.TrackUpdates[trackKey(&record.State.Tracks[i])]
+	}
+	return &record, nil
+}
@@ -0,0 +1,105 @@
+package jukebox_syncer
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	pb "roll20-audio-bouncer/proto"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJukeboxSyncer_RestoreWithoutStore(t *testing.T) {
+	s := NewJukeboxSyncer(&mockMixer{})
+	assert.NoError(t, s.Restore())
+}
+
+func TestJukeboxSyncer_RestoreEmptyStore(t *testing.T) {
+	s := NewJukeboxSyncer(&mockMixer{}, WithStateStore(&mockStore{}))
+	assert.NoError(t, s.Restore())
+	assert.Empty(t, s.records)
+}
+
+// A restarted syncer must resume the records where it left them
+func TestJukeboxSyncer_Restore(t *testing.T) {
+	store := &mockStore{}
+	refDate := time.Now()
+	s := NewJukeboxSyncer(&mockMixer{}, WithStateStore(store))
+	assert.NoError(t, s.Start("1"))
+	assert.NoError(t, s.Start("2"))
+	err := s.Handle(&R20State{Rid: "1", Date: refDate, ReceivedAt: refDate, Tracks: []R20Track{{Url: "a", Playing: true}}})
+	assert.NoError(t, err)
+	waitIdle(t, s, "1")
+
+	m := &mockMixer{}
+	restarted := NewJukeboxSyncer(m, WithStateStore(store))
+	assert.NoError(t, restarted.Restore())
+	assert.Len(t, restarted.records, 2)
+	assert.Equal(t, refDate.UTC(), restarted.records["1"].state.Tracks[0].LastUpdate.UTC())
+	// The track was already playing before the restart
+	err = restarted.Handle(&R20State{Rid: "1", Date: refDate.Add(time.Second), Tracks: []R20Track{{Url: "a", Playing: true}, {Url: "b", Playing: true}}})
+	assert.NoError(t, err)
+	waitIdle(t, restarted, "1")
+	assert.Len(t, m.events, 1)
+	assert.Equal(t, "b", m.events[0].EvtId)
+	// Sequence numbers keep increasing across restarts
+	assert.Equal(t, uint64(2), m.events[0].Seq)
+	err = restarted.Handle(&R20State{Rid: "2", Date: refDate, Tracks: []R20Track{{Url: "a", Playing: true}}})
+	assert.NoError(t, err)
+}
+
+func TestJukeboxSyncer_StopDeletesPersistedRecord(t *testing.T) {
+	store := &mockStore{}
+	s := NewJukeboxSyncer(&mockMixer{}, WithStateStore(store))
+	assert.NoError(t, s.Start("1"))
+	err := s.Handle(&R20State{Rid: "1", Tracks: []R20Track{{Url: "a", Playing: true}}})
+	assert.NoError(t, err)
+	_, err = s.Stop("1")
+	assert.NoError(t, err)
+	assert.NotContains(t, store.values, recordKey("1"))
+	assert.JSONEq(t, `[]`, string(store.values[startedRecordsKey]))
+}
+
+// A failing store must not prevent the syncer from working
+func TestJukeboxSyncer_StoreError(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m, WithStateStore(&mockStore{err: fmt.Errorf("Test")}))
+	assert.NoError(t, s.Start("1"))
+	err := s.Handle(&R20State{Rid: "1", Tracks: []R20Track{{Url: "a", Playing: true}}})
+	assert.NoError(t, err)
+	waitIdle(t, s, "1")
+	assert.Len(t, m.events, 1)
+	assert.True(t, m.events[0].Type == pb.EventType_PLAY, "expected play event")
+	_, err = s.Stop("1")
+	assert.NoError(t, err)
+}
+
+type mockStore struct {
+	values map[string][]byte
+	// Error returned by every operation
+	err error
+	mu  sync.Mutex
+}
+
+func (ms *mockStore) Get(key string) ([]byte, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.values[key], ms.err
+}
+
+func (ms *mockStore) Set(key string, value []byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.values == nil {
+		ms.values = map[string][]byte{}
+	}
+	ms.values[key] = value
+	return ms.err
+}
+
+func (ms *mockStore) Delete(key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.values, key)
+	return ms.err
+}
@@ -0,0 +1,95 @@
+package jukebox_syncer
+
+import (
+	pb "roll20-audio-bouncer/proto"
+	"sort"
+)
+
+// Find the playlist with the given ID in a state
+func findPlaylist(state *R20State, id string) *R20Playlist {
+	for _, p := range state.Playlists {
+		if p.Id == id {
+			return &p
+		}
+	}
+	return nil
+}
+
+// Check if Roll20 is playing the tracks of a playlist on its own.
+// When it is, a track ending and another one starting are a single transition
+func isAutoPlaying(mode PlaylistMode) bool {
+	switch mode {
+	case PlaylistModePlayThrough, PlaylistModeShuffle, PlaylistModeTogether, PlaylistModeLoop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Check if an event is removing a track from the mix
+func isStopping(evt *pb.Event) bool {
+	return evt.Type == pb.EventType_STOP || evt.Type == pb.EventType_PAUSE
+}
+
+// Reorder the events of a delta so that the mixer never plays an incoming track over one that is going away,
+// and each transition within an auto-playing playlist is sent as a single ordered sequence : the ending tracks
+// are stopped, then the next ones are started. Events removing a track from the mix go first, then the transitions,
+// then the other events, each keeping their relative order
+func orderTransitions(events []*pb.Event, old, new *R20State) []*pb.Event {
+	// Find the playlist of each track, removed tracks can only be found in the old state
+	playlistOf := map[string]string{}
+	for _, state := range []*R20State{old, new} {
+		if state == nil {
+			continue
+		}
+		for _, t := range state.Tracks {
+			if p := findPlaylist(new, t.PlaylistId); p != nil && isAutoPlaying(p.Mode) {
+				playlistOf[trackKey(&t)] = p.Id
+			}
+		}
+	}
+
+	// Group the events by playlist, stopping events first. Other events are a group on their own
+	var units [][]*pb.Event
+	groups := map[string]int{}
+	for _, evt := range events {
+		id, ok := playlistOf[evt.EvtId]
+		if !ok {
+			units = append(units, []*pb.Event{evt})
+			continue
+		}
+		i, seen := groups[id]
+		if !seen {
+			i = len(units)
+			groups[id] = i
+			units = append(units, nil)
+		}
+		if isStopping(evt) {
+			stops := 0
+			for stops < len(units[i]) && isStopping(units[i][stops]) {
+				stops++
+			}
+			units[i] = append(units[i][:stops], append([]*pb.Event{evt}, units[i][stops:]...)...)
+		} else {
+			units[i] = append(units[i], evt)
+		}
+	}
+
+	// Groups only stopping tracks, then the transitions, then the groups without any stopping event
+	rank := func(unit []*pb.Event) int {
+		switch {
+		case isStopping(unit[len(unit)-1]):
+			return 0
+		case isStopping(unit[0]):
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(units, func(i, j int) bool { return rank(units[i]) < rank(units[j]) })
+	ordered := make([]*pb.Event, 0, len(events))
+	for _, unit := range units {
+		ordered = append(ordered, unit...)
+	}
+	return ordered
+}
@@ -0,0 +1,100 @@
+package jukebox_syncer
+
+import (
+	"github.com/stretchr/testify/assert"
+	pb "roll20-audio-bouncer/proto"
+	"testing"
+)
+
+// Without playlists, the events removing a track from the mix go first
+func TestOrderTransitions_NoPlaylist(t *testing.T) {
+	evts := []*pb.Event{
+		{EvtId: "a", Type: pb.EventType_PLAY},
+		{EvtId: "b", Type: pb.EventType_STOP},
+		{EvtId: "c", Type: pb.EventType_VOLUME},
+		{EvtId: "d", Type: pb.EventType_PAUSE},
+	}
+	assert.Equal(t, []string{"b", "d", "a", "c"}, eventIds(orderTransitions(evts, nil, &R20State{})))
+}
+
+func eventIds(events []*pb.Event) []string {
+	var ids []string
+	for _, evt := range events {
+		ids = append(ids, evt.EvtId)
+	}
+	return ids
+}
+
+// A track ending and the next one starting in a play-through playlist must be sent as stop then play
+func TestOrderTransitions_PlayThrough(t *testing.T) {
+	old := &R20State{Tracks: []R20Track{
+		{Url: "a", TrackId: "a", PlaylistId: "p", Playing: true},
+		{Url: "b", TrackId: "b", PlaylistId: "p"},
+		{Url: "c"},
+	}}
+	new := &R20State{
+		Playlists: []R20Playlist{{Id: "p", Mode: PlaylistModePlayThrough}},
+		Tracks: []R20Track{
+			{Url: "c", Playing: true},
+			{Url: "b", TrackId: "b", PlaylistId: "p", Playing: true},
+			{Url: "a", TrackId: "a", PlaylistId: "p"},
+		},
+	}
+	evts, err := stateDelta(old, new)
+	assert.NoError(t, err)
+	ordered := orderTransitions(evts, old, new)
+	assert.Len(t, ordered, 3)
+	assert.Equal(t, "p/a", ordered[0].EvtId)
+	assert.True(t, ordered[0].Type == pb.EventType_STOP, "expected stop event")
+	assert.Equal(t, "p/b", ordered[1].EvtId)
+	assert.True(t, ordered[1].Type == pb.EventType_PLAY, "expected play event")
+	assert.Equal(t, "c", ordered[2].EvtId)
+}
+
+// A transition is never split, stopped tracks unrelated to it go before it and started ones after it
+func TestOrderTransitions_Mixed(t *testing.T) {
+	evts := []*pb.Event{
+		{EvtId: "c", Type: pb.EventType_PLAY},
+		{EvtId: "p/b", Type: pb.EventType_PLAY},
+		{EvtId: "d", Type: pb.EventType_STOP},
+		{EvtId: "p/a", Type: pb.EventType_STOP},
+	}
+	state := &R20State{
+		Playlists: []R20Playlist{{Id: "p", Mode: PlaylistModePlayThrough}},
+		Tracks:    []R20Track{{TrackId: "a", PlaylistId: "p"}, {TrackId: "b", PlaylistId: "p"}},
+	}
+	assert.Equal(t, []string{"d", "p/a", "p/b", "c"}, eventIds(orderTransitions(evts, nil, state)))
+}
+
+// Tracks of a single mode playlist are independent, they are only ordered like tracks without a playlist
+func TestOrderTransitions_SingleMode(t *testing.T) {
+	evts := []*pb.Event{
+		{EvtId: "p/b", Type: pb.EventType_PLAY},
+		{EvtId: "p/a", Type: pb.EventType_STOP},
+	}
+	state := &R20State{
+		Playlists: []R20Playlist{{Id: "p", Mode: PlaylistModeSingle}},
+		Tracks:    []R20Track{{TrackId: "a", PlaylistId: "p"}, {TrackId: "b", PlaylistId: "p"}},
+	}
+	ordered := orderTransitions(evts, nil, state)
+	assert.Equal(t, []string{"p/a", "p/b"}, eventIds(ordered))
+}
+
+// Tracks removed from the state are found in the old one
+func TestOrderTransitions_RemovedTrack(t *testing.T) {
+	evts := []*pb.Event{
+		{EvtId: "p/b", Type: pb.EventType_PLAY},
+		{EvtId: "p/b", Type: pb.EventType_VOLUME},
+		{EvtId: "p/a", Type: pb.EventType_STOP},
+	}
+	old := &R20State{Tracks: []R20Track{{TrackId: "a", PlaylistId: "p"}}}
+	new := &R20State{
+		Playlists: []R20Playlist{{Id: "p", Mode: PlaylistModeShuffle}},
+		Tracks:    []R20Track{{TrackId: "b", PlaylistId: "p"}},
+	}
+	ordered := orderTransitions(evts, old, new)
+	assert.Len(t, ordered, 3)
+	assert.True(t, ordered[0].Type == pb.EventType_STOP, "expected stop event")
+	assert.True(t, ordered[1].Type == pb.EventType_PLAY, "expected play event")
+	assert.True(t, ordered[2].Type == pb.EventType_VOLUME, "expected volume event")
+}
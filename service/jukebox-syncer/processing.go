@@ -0,0 +1,51 @@
+package jukebox_syncer
+
+import "time"
+
+// How the syncer turns states into events. Shared by the service and the reprocess command,
+// so that a captured session is processed again exactly like it was in production
+type ProcessingConfig struct {
+	// How long states are held to be reordered
+	ReorderWindow time.Duration
+	// How the Roll20 volume sliders are mapped to amplitudes, by default and by campaign, see ParseVolumeCurve
+	VolumeCurve          string
+	CampaignVolumeCurves string
+	// How long the volume and seek changes of a track are held to be merged
+	DebounceWindow time.Duration
+	// Plays and stops within the period making a track flapping, disabled if zero
+	FlappingToggles int
+	FlappingPeriod  time.Duration
+}
+
+// Processing of the service when nothing is configured
+func DefaultProcessingConfig() ProcessingConfig {
+	return ProcessingConfig{
+		ReorderWindow:   500 * time.Millisecond,
+		VolumeCurve:     "linear",
+		DebounceWindow:  250 * time.Millisecond,
+		FlappingToggles: 6,
+		FlappingPeriod:  2 * time.Second,
+	}
+}
+
+// Options of the syncer applying the configuration
+func (c ProcessingConfig) Options() ([]Option, error) {
+	opts := []Option{
+		WithReorderWindow(c.ReorderWindow),
+		WithDebounceWindow(c.DebounceWindow),
+		WithFlappingLimit(c.FlappingToggles, c.FlappingPeriod),
+	}
+	curve, err := ParseVolumeCurve(c.VolumeCurve)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, WithVolumeCurve(curve))
+	campaignCurves, err := ParseCampaignVolumeCurves(c.CampaignVolumeCurves)
+	if err != nil {
+		return nil, err
+	}
+	for id, curve := range campaignCurves {
+		opts = append(opts, WithCampaignVolumeCurve(id, curve))
+	}
+	return opts, nil
+}
@@ -0,0 +1,31 @@
+package jukebox_syncer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestProcessingConfig_Options(t *testing.T) {
+	cfg := DefaultProcessingConfig()
+	cfg.VolumeCurve = "quadratic"
+	cfg.CampaignVolumeCurves = "1=linear"
+	opts, err := cfg.Options()
+	assert.NoError(t, err)
+	s := NewJukeboxSyncer(&mockMixer{}, opts...)
+	assert.Equal(t, cfg.ReorderWindow, s.reorderWindow)
+	assert.Equal(t, cfg.DebounceWindow, s.debounceWindow)
+	assert.Equal(t, cfg.FlappingToggles, s.flappingToggles)
+	assert.InDelta(t, 0.5, s.curveOf("1")(0.5), 1e-9)
+	assert.InDelta(t, 0.25, s.curveOf("2")(0.5), 1e-9)
+}
+
+func TestProcessingConfig_InvalidCurve(t *testing.T) {
+	cfg := DefaultProcessingConfig()
+	cfg.VolumeCurve = "test"
+	_, err := cfg.Options()
+	assert.Error(t, err)
+	cfg = DefaultProcessingConfig()
+	cfg.CampaignVolumeCurves = "1"
+	_, err = cfg.Options()
+	assert.Error(t, err)
+}
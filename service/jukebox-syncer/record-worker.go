@@ -0,0 +1,251 @@
+package jukebox_syncer
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log/slog"
+	"roll20-audio-bouncer/internal/journal"
+	pb "roll20-audio-bouncer/proto"
+	"sync"
+	"time"
+)
+
+// Number of tasks a record can have pending before its producers are blocked
+const workerQueueSize = 256
+
+// Another user already sent the same jukebox state
+var errDuplicate = errors.New("duplicate of the last applied state")
+
+// Everything the syncer knows about a single record.
+// All the fields but the queue are only accessed by the worker goroutine,
+// so tasks are processed in order and records never wait on each other
+type recordWorker struct {
+	id     string
+	syncer *JukeboxSyncer
+	// Last known state of the game
+	state *R20State
+	// States waiting to be applied
+	buffer *reorderBuffer
+	// Pending flush of the reorder buffer
+	timer *time.Timer
+	// Set once the recording stopped, any state still queued is then ignored
+	stopped bool
+	// Sequence number of the last event sent
+	seq uint64
+	// When the recording started
+	startedAt time.Time
+	// Last time a state or a heartbeat was received
+	lastActivity time.Time
+	// Pending inactivity check, and recording duration limit
+	inactivityTimer  *time.Timer
+	maxDurationTimer *time.Timer
+	// Tracks whose changes are held back from the mixer, by identity, and their pending release
+	held         map[string]*heldTrack
+	releaseTimer *time.Timer
+	// Recent plays and stops of each track, to detect flapping
+	toggles map[string][]time.Time
+
+	tasks chan func()
+	// Guards the queue closing
+	mu     sync.Mutex
+	closed bool
+}
+
+func newRecordWorker(id string, syncer *JukeboxSyncer) *recordWorker {
+	w := &recordWorker{
+		id:      id,
+		syncer:  syncer,
+		buffer:  newReorderBuffer(syncer.reorderWindow),
+		held:    map[string]*heldTrack{},
+		toggles: map[string][]time.Time{},
+		tasks:   make(chan func(), workerQueueSize),
+	}
+	go w.run()
+	return w
+}
+
+func (w *recordWorker) run() {
+	for task := range w.tasks {
+		task()
+	}
+}
+
+// Queue a task, without waiting for it to be processed
+func (w *recordWorker) enqueue(task func()) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return fmt.Errorf("record %s has stopped", w.id)
+	}
+	w.tasks <- task
+	return nil
+}
+
+// Queue a task and wait for it to be processed
+func (w *recordWorker) do(task func() error) error {
+	done := make(chan error, 1)
+	if err := w.enqueue(func() { done <- task() }); err != nil {
+		return err
+	}
+	return <-done
+}
+
+// Stop accepting tasks. Already queued tasks are still processed
+func (w *recordWorker) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	w.closed = true
+	close(w.tasks)
+}
+
+// Buffer a new state, applying all the states that have been held long enough
+func (w *recordWorker) handle(new *R20State) {
+	if w.stopped {
+		slog.Debug(fmt.Sprintf("[Jukebox syncer] :: ignoring state received after record %s stopped", w.id))
+		new.done(fmt.Errorf("record %s already stopped", w.id))
+		return
+	}
+	w.touch()
+	now := time.Now()
+	if err := w.buffer.push(new, now); err != nil {
+		slog.Warn(fmt.Sprintf("[Jukebox syncer] :: dropping late state for record %s (%d late states so far) : %s", w.id, w.buffer.late, err))
+		new.done(err)
+		return
+	}
+	w.flush(now)
+}
+
+// Apply all the states released by the reorder buffer, and make sure the remaining ones will be applied later on
+func (w *recordWorker) flush(now time.Time) {
+	if err := w.applyAll(w.buffer.release(now)); err != nil {
+		slog.Error(fmt.Sprintf("[Jukebox syncer] :: while applying states of record %s : %s", w.id, err))
+	}
+	if w.timer != nil {
+		return
+	}
+	next, ok := w.buffer.nextRelease(now)
+	if !ok {
+		return
+	}
+	w.timer = time.AfterFunc(next, func() {
+		// The record may have stopped in the meantime, there is nothing left to flush then
+		_ = w.enqueue(func() {
+			w.timer = nil
+			if !w.stopped {
+				w.flush(time.Now())
+			}
+		})
+	})
+}
+
+// Apply a list of ordered states, stopping at the first error. Duplicate states are only skipped
+func (w *recordWorker) applyAll(states []*R20State) error {
+	for i, state := range states {
+		err := w.apply(state)
+		state.done(err)
+		if errors.Is(err, errDuplicate) {
+			continue
+		}
+		if err != nil {
+			for _, skipped := range states[i+1:] {
+				skipped.done(fmt.Errorf("not applied, a previous state failed : %w", err))
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Compute the delta between the last known state of the record and a new one, and send it to the mixer
+func (w *recordWorker) apply(new *R20State) error {
+	// Multiple users may be sending the exact same jukebox state, only the first one is relevant.
+	// While tracks are held, the last known state is what the mixer knows, and going back to it cancels the held changes
+	if w.state != nil && len(w.held) == 0 && isSameSnapshot(w.state, new) {
+		slog.Debug(fmt.Sprintf("[Jukebox syncer] :: ignoring duplicate state from user %s for record %s", new.Uid, new.Rid))
+		return errDuplicate
+	}
+	merged, err := mergeStates(w.state, new)
+	if err != nil {
+		return err
+	}
+	applyVolumeCurve(merged, w.syncer.curveOf(w.id))
+	var events []*pb.Event
+	if w.state == nil {
+		// This is the first ever state we're receiving
+		events, err = scanForPlay(merged)
+	} else {
+		events, err = stateDelta(w.state, merged)
+	}
+	if err != nil {
+		return err
+	}
+	events = orderTransitions(events, w.state, merged)
+	if w.state != nil {
+		events = w.hold(events, merged)
+	}
+	w.send(events, merged, new.Date, new.ReceivedAt)
+	return nil
+}
+
+// Send the events leading from the last known state to a new one, which becomes the last known state.
+// Events are stamped with the date of the state they come from, and when it was received
+func (w *recordWorker) send(events []*pb.Event, new *R20State, stateDate, receivedAt time.Time) {
+	// Tracks with an event that couldn't be delivered, by identity
+	failed := map[string]bool{}
+	for _, evt := range events {
+		evt.StateDate = timestamppb.New(stateDate)
+		evt.ReceivedAt = timestamppb.New(receivedAt)
+		entry := journal.Entry{Kind: journal.KindEvent, Event: evt, Title: trackTitle(evt, new, w.state)}
+		// The following events of a track are meaningless if the mixer missed one of them
+		if failed[evt.EvtId] {
+			entry.Skipped = true
+			w.syncer.journalEntry(w.id, entry, errSkipped)
+			continue
+		}
+		w.seq++
+		evt.Seq = w.seq
+		err := w.syncer.mixer.Send(evt)
+		w.syncer.journalEntry(w.id, entry, err)
+		// Any error here is non-fatal
+		if err != nil {
+			slog.Warn(fmt.Sprintf("event with url %s error %s", evt.AssetUrl, err))
+			failed[evt.EvtId] = true
+		}
+
+	}
+	// The mixer didn't apply the changes of these tracks, the next delta must send them again
+	for key := range failed {
+		revertTrack(new, w.state, key)
+	}
+	w.state = new
+	w.syncer.saveRecord(w)
+}
+
+// Apply the states still waiting in the reorder buffer, and stop the recording
+func (w *recordWorker) stop() (*pb.StopResult, error) {
+	if err := w.applyAll(w.buffer.drain()); err != nil {
+		slog.Warn(fmt.Sprintf("[Jukebox syncer] :: while applying buffered states of record %s : %s", w.id, err))
+	}
+	w.release(true)
+	// Send stop signal to live audio mixer, which answers with the storage key of the recording
+	reply, err := w.syncer.mixer.Stop(w.id)
+	w.syncer.journalEntry(w.id, journal.Entry{Kind: journal.KindStop, Message: reply.GetStreamMessage()}, err)
+	if err != nil {
+		return nil, err
+	}
+	w.stopped = true
+	for _, timer := range []*time.Timer{w.timer, w.inactivityTimer, w.maxDurationTimer, w.releaseTimer} {
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	return reply, nil
+}
+
+func (w *recordWorker) stats() *RecordStats {
+	return &RecordStats{BufferedStates: len(w.buffer.pending), LateStates: w.buffer.late, HeldTracks: len(w.held)}
+}
@@ -0,0 +1,100 @@
+package jukebox_syncer
+
+import (
+	"fmt"
+	"time"
+)
+
+// A state waiting in the reorder buffer
+type bufferedState struct {
+	state   *R20State
+	arrival time.Time
+}
+
+// Roll20 states are sent using independent HTTP requests, which may reach us out of order.
+// The reorder buffer holds each state of a record for a fixed window, and releases them sorted
+// by date, the listener sequence number breaking ties.
+type reorderBuffer struct {
+	window  time.Duration
+	pending []bufferedState
+	// Last state released by the buffer, any state older than this one is late
+	last *R20State
+	// Number of states that arrived after the window expired
+	late int
+}
+
+func newReorderBuffer(window time.Duration) *reorderBuffer {
+	return &reorderBuffer{window: window}
+}
+
+// Check if state a should be applied before state b.
+// States are ordered by date, which is the only order shared by all the listeners of a record
+func isBefore(a, b *R20State) bool {
+	if !a.Date.Equal(b.Date) {
+		return a.Date.Before(b.Date)
+	}
+	// Sequence numbers are only meaningful for a single listener
+	return a.Uid == b.Uid && a.Seq < b.Seq
+}
+
+// Add a state to the buffer. A state older than the last released one is too late
+// to be applied and is rejected
+func (rb *reorderBuffer) push(state *R20State, now time.Time) error {
+	if rb.last != nil && isBefore(state, rb.last) {
+		rb.late++
+		return fmt.Errorf("state dated %s arrived after the reorder window (%s) expired, last applied state is dated %s", state.Date, rb.window, rb.last.Date)
+	}
+	// Keep the pending states sorted
+	i := len(rb.pending)
+	for i > 0 && isBefore(state, rb.pending[i-1].state) {
+		i--
+	}
+	rb.pending = append(rb.pending, bufferedState{})
+	copy(rb.pending[i+1:], rb.pending[i:])
+	rb.pending[i] = bufferedState{state: state, arrival: now}
+	return nil
+}
+
+// Pop all the states that have been held long enough, in order.
+// As the pending states are sorted, every state before an expired one is released too
+func (rb *reorderBuffer) release(now time.Time) []*R20State {
+	last := -1
+	for i, p := range rb.pending {
+		if !now.Before(p.arrival.Add(rb.window)) {
+			last = i
+		}
+	}
+	return rb.pop(last + 1)
+}
+
+// Pop every pending state, regardless of the window
+func (rb *reorderBuffer) drain() []*R20State {
+	return rb.pop(len(rb.pending))
+}
+
+func (rb *reorderBuffer) pop(n int) []*R20State {
+	if n == 0 {
+		return nil
+	}
+	states := make([]*R20State, 0, n)
+	for _, p := range rb.pending[:n] {
+		states = append(states, p.state)
+	}
+	rb.pending = rb.pending[n:]
+	rb.last = states[n-1]
+	return states
+}
+
+// Time until the next pending state can be released. False if the buffer is empty
+func (rb *reorderBuffer) nextRelease(now time.Time) (time.Duration, bool) {
+	if len(rb.pending) == 0 {
+		return 0, false
+	}
+	next := rb.pending[0].arrival.Add(rb.window)
+	for _, p := range rb.pending[1:] {
+		if exp := p.arrival.Add(rb.window); exp.Before(next) {
+			next = exp
+		}
+	}
+	return next.Sub(now), true
+}
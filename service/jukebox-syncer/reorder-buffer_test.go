@@ -0,0 +1,87 @@
+package jukebox_syncer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestReorderBuffer_NoWindow(t *testing.T) {
+	rb := newReorderBuffer(0)
+	now := time.Now()
+	err := rb.push(&R20State{Date: now}, now)
+	assert.NoError(t, err)
+	assert.Len(t, rb.release(now), 1)
+	_, ok := rb.nextRelease(now)
+	assert.False(t, ok)
+}
+
+func TestReorderBuffer_SortByDate(t *testing.T) {
+	rb := newReorderBuffer(time.Second)
+	now := time.Now()
+	assert.NoError(t, rb.push(&R20State{Date: now.Add(2 * time.Second)}, now))
+	assert.NoError(t, rb.push(&R20State{Date: now}, now))
+	assert.NoError(t, rb.push(&R20State{Date: now.Add(time.Second)}, now))
+	// Window not expired yet
+	assert.Len(t, rb.release(now), 0)
+	next, ok := rb.nextRelease(now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, next)
+	states := rb.release(now.Add(time.Second))
+	assert.Len(t, states, 3)
+	for i, s := range states {
+		assert.Equal(t, now.Add(time.Duration(i)*time.Second), s.Date)
+	}
+}
+
+// The sequence number breaks ties between states of a single listener sharing a date
+func TestReorderBuffer_SortBySeq(t *testing.T) {
+	rb := newReorderBuffer(time.Second)
+	now := time.Now()
+	assert.NoError(t, rb.push(&R20State{Uid: "a", Seq: 2, Date: now}, now))
+	assert.NoError(t, rb.push(&R20State{Uid: "a", Seq: 1, Date: now}, now))
+	states := rb.drain()
+	assert.Len(t, states, 2)
+	assert.Equal(t, uint64(1), states[0].Seq)
+	assert.Equal(t, uint64(2), states[1].Seq)
+}
+
+// States of several listeners are ordered by date, whatever their sequence numbers
+func TestReorderBuffer_SortMultipleListeners(t *testing.T) {
+	rb := newReorderBuffer(time.Second)
+	now := time.Now()
+	assert.NoError(t, rb.push(&R20State{Uid: "a", Seq: 5, Date: now.Add(2 * time.Second)}, now))
+	assert.NoError(t, rb.push(&R20State{Uid: "b", Seq: 1, Date: now.Add(time.Second)}, now))
+	assert.NoError(t, rb.push(&R20State{Uid: "a", Seq: 4, Date: now}, now))
+	states := rb.drain()
+	assert.Len(t, states, 3)
+	for i, s := range states {
+		assert.Equal(t, now.Add(time.Duration(i)*time.Second), s.Date)
+	}
+	// A state of the second listener older than the last released one is late, even with a lower sequence number
+	assert.Error(t, rb.push(&R20State{Uid: "b", Seq: 2, Date: now.Add(time.Second)}, now))
+	assert.NoError(t, rb.push(&R20State{Uid: "b", Seq: 2, Date: now.Add(3 * time.Second)}, now))
+}
+
+// Releasing a state must release all the older ones first
+func TestReorderBuffer_ReleaseInOrder(t *testing.T) {
+	rb := newReorderBuffer(time.Second)
+	now := time.Now()
+	assert.NoError(t, rb.push(&R20State{Date: now.Add(time.Second)}, now))
+	// Arrived later, but is older
+	assert.NoError(t, rb.push(&R20State{Date: now}, now.Add(500*time.Millisecond)))
+	states := rb.release(now.Add(time.Second))
+	assert.Len(t, states, 2)
+	assert.Equal(t, now, states[0].Date)
+}
+
+func TestReorderBuffer_LateState(t *testing.T) {
+	rb := newReorderBuffer(time.Second)
+	now := time.Now()
+	assert.NoError(t, rb.push(&R20State{Date: now}, now))
+	assert.Len(t, rb.release(now.Add(time.Second)), 1)
+	err := rb.push(&R20State{Date: now.Add(-time.Second)}, now.Add(time.Second))
+	assert.Error(t, err)
+	assert.Equal(t, 1, rb.late)
+	assert.Len(t, rb.pending, 0)
+}
@@ -0,0 +1,74 @@
+package jukebox_syncer
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log/slog"
+	"roll20-audio-bouncer/internal/journal"
+	pb "roll20-audio-bouncer/proto"
+	"time"
+)
+
+// The record isn't handled by the syncer
+var ErrNotStarted = errors.New("record hasn't started yet")
+
+// Send the full state of a record to the mixer, so that it plays exactly what the jukebox is playing.
+// The mixer may have missed some deltas, after a restart or when the stream of the record is opened again
+func (es *JukeboxSyncer) Resync(id string) error {
+	w, ok := es.worker(id)
+	if !ok {
+		return fmt.Errorf("record %s : %w", id, ErrNotStarted)
+	}
+	return w.do(w.resync)
+}
+
+func (w *recordWorker) resync() error {
+	// Nothing has been sent to the mixer yet
+	if w.state == nil || w.stopped {
+		return nil
+	}
+	// The snapshot must include the latest changes
+	w.release(true)
+	// The snapshot is dated when the positions of its tracks were computed
+	now := timeNow()
+	evt := makeSnapshot(w.state, now)
+	w.seq++
+	evt.Seq = w.seq
+	evt.StateDate = timestamppb.New(now)
+	evt.ReceivedAt = timestamppb.New(now)
+	err := w.syncer.mixer.Send(evt)
+	w.syncer.journalEntry(w.id, journal.Entry{Kind: journal.KindEvent, Event: evt}, err)
+	w.syncer.saveRecord(w)
+	if err != nil {
+		return fmt.Errorf("could not resync record %s : %w", w.id, err)
+	}
+	slog.Info(fmt.Sprintf("[Jukebox syncer] :: resynced record %s, %d tracks playing", w.id, len(evt.Tracks)))
+	return nil
+}
+
+// Build a SYNC event listing the tracks of a state playing at the given time
+func makeSnapshot(state *R20State, now time.Time) *pb.Event {
+	evt := &pb.Event{RecordId: state.Rid, Type: pb.EventType_SYNC}
+	for _, t := range state.Tracks {
+		if !t.Playing {
+			continue
+		}
+		receivedAt := t.LastUpdate
+		if receivedAt.IsZero() {
+			receivedAt = state.ReceivedAt
+		}
+		pos, playing := currentPosition(&t, receivedAt, now)
+		if !playing {
+			continue
+		}
+		evt.Tracks = append(evt.Tracks, &pb.TrackSnapshot{
+			EvtId:          trackKey(&t),
+			AssetUrl:       t.Url,
+			Loop:           t.Loop,
+			VolumeDb:       computeVolumeDb(1, trackAmplitude(&t)),
+			SeekPositionMs: pos.Milliseconds(),
+		})
+	}
+	return evt
+}
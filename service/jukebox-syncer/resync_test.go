@@ -0,0 +1,90 @@
+package jukebox_syncer
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	pb "roll20-audio-bouncer/proto"
+	"testing"
+	"time"
+)
+
+func TestJukeboxSyncer_ResyncNotStarted(t *testing.T) {
+	s := NewJukeboxSyncer(&mockMixer{})
+	assert.True(t, errors.Is(s.Resync("1"), ErrNotStarted))
+}
+
+// Nothing was sent to the mixer yet, there is nothing to resync
+func TestJukeboxSyncer_ResyncWithoutState(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m)
+	assert.NoError(t, s.Start("1"))
+	assert.NoError(t, s.Resync("1"))
+	assert.Empty(t, m.events)
+}
+
+// The snapshot lists the playing tracks only, with their current position
+func TestJukeboxSyncer_Resync(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m)
+	assert.NoError(t, s.Start("1"))
+	refDate := time.Now().Add(-10 * time.Second)
+	assert.NoError(t, s.Handle(&R20State{Rid: "1", Date: refDate, ReceivedAt: refDate, Tracks: []R20Track{
+		{Url: "a", Playing: true, Loop: true, Volume: 50, Progress: 0.5, Duration: "100"},
+		{Url: "b", Volume: 100},
+	}}))
+	assert.NoError(t, s.Resync("1"))
+	assert.Len(t, m.events, 2)
+	evt := m.events[1]
+	assert.Equal(t, pb.EventType_SYNC, evt.Type)
+	assert.Equal(t, uint64(2), evt.Seq)
+	assert.Len(t, evt.Tracks, 1)
+	assert.Equal(t, "a", evt.Tracks[0].EvtId)
+	assert.True(t, evt.Tracks[0].Loop)
+	assert.InDelta(t, computeVolumeDb(1, 0.5), evt.Tracks[0].VolumeDb, 1e-9)
+	assert.InDelta(t, 60000, evt.Tracks[0].SeekPositionMs, 1000)
+}
+
+// The snapshot is dated when the positions were computed, not when the state was sent
+func TestJukeboxSyncer_ResyncDate(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m)
+	assert.NoError(t, s.Start("1"))
+	refDate := time.Now().Add(-10 * time.Second)
+	assert.NoError(t, s.Handle(&R20State{Rid: "1", Date: refDate, ReceivedAt: refDate, Tracks: []R20Track{{Url: "a", Playing: true, Progress: 0.5, Duration: "100"}}}))
+	waitIdle(t, s, "1")
+	restore := mockNow(refDate.Add(20 * time.Second))
+	defer restore()
+	assert.NoError(t, s.Resync("1"))
+	evt := m.events[1]
+	assert.True(t, refDate.Add(20*time.Second).Equal(evt.StateDate.AsTime()))
+	assert.True(t, evt.StateDate.AsTime().Equal(evt.ReceivedAt.AsTime()))
+	assert.Equal(t, int64(70000), evt.Tracks[0].SeekPositionMs)
+}
+
+// Positions are computed on the clock of the syncer, whatever the clock of the browser says
+func TestJukeboxSyncer_ResyncBrowserClockSkew(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m)
+	assert.NoError(t, s.Start("1"))
+	refDate := time.Now()
+	assert.NoError(t, s.Handle(&R20State{Rid: "1", Date: refDate.Add(time.Hour), ReceivedAt: refDate, Tracks: []R20Track{{Url: "a", Playing: true, Progress: 0.5, Duration: "100"}}}))
+	waitIdle(t, s, "1")
+	restore := mockNow(refDate.Add(10 * time.Second))
+	defer restore()
+	assert.NoError(t, s.Resync("1"))
+	assert.Equal(t, int64(60000), m.events[1].Tracks[0].SeekPositionMs)
+}
+
+// The held changes are part of the snapshot
+func TestJukeboxSyncer_ResyncReleasesHeldTracks(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m, WithDebounceWindow(time.Hour))
+	assert.NoError(t, s.Start("1"))
+	refDate := time.Now()
+	assert.NoError(t, s.Handle(&R20State{Rid: "1", Date: refDate, Tracks: []R20Track{{Url: "a", Playing: true, Volume: 100}}}))
+	assert.NoError(t, s.Handle(&R20State{Rid: "1", Date: refDate.Add(time.Second), Tracks: []R20Track{{Url: "a", Playing: true, Volume: 50}}}))
+	assert.NoError(t, s.Resync("1"))
+	assert.Len(t, m.events, 3)
+	assert.Equal(t, pb.EventType_VOLUME, m.events[1].Type)
+	assert.InDelta(t, computeVolumeDb(1, 0.5), m.events[2].Tracks[0].VolumeDb, 1e-9)
+}
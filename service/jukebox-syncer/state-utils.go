@@ -4,22 +4,150 @@ import (
 	"fmt"
 	"log/slog"
 	"math"
+	"net/url"
 	pb "roll20-audio-bouncer/proto"
 	"strconv"
 	"strings"
 	"time"
 )
 
-func findMatching(old *R20State, url string) *R20Track {
+// Current time, overridable for testing purposes
+var timeNow = time.Now
+
+// Query string parameters used to sign an asset URL. They change each time the URL is signed again
+var volatileParams = []string{"signature", "expires", "key-pair-id", "policy", "awsaccesskeyid", "x-amz-"}
+
+// Find the track with the given identity in a state
+func findMatching(old *R20State, key string) *R20Track {
 	for _, oldT := range old.Tracks {
-		if oldT.Url == url {
+		if trackKey(&oldT) == key {
 			return &oldT
 		}
-
 	}
 	return nil
 }
 
+// Stable identity of a track.
+// The Roll20 track ID is used when available, scoped to its playlist as the same asset
+// can be used in multiple playlists. Older listeners only send the URL.
+func trackKey(track *R20Track) string {
+	if track.TrackId == "" {
+		return normalizeUrl(track.Url)
+	}
+	if track.PlaylistId == "" {
+		return track.TrackId
+	}
+	return track.PlaylistId + "/" + track.TrackId
+}
+
+// Remove the signature of an asset URL.
+// Roll20 signs again the "My Audio" URLs from time to time, which must not be seen as a new track
+func normalizeUrl(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.RawQuery == "" {
+		return rawUrl
+	}
+	query := u.Query()
+	for param := range query {
+		lower := strings.ToLower(param)
+		for _, volatile := range volatileParams {
+			if strings.HasPrefix(lower, volatile) {
+				query.Del(param)
+				break
+			}
+		}
+	}
+	// Encode sorts the parameters, making the result deterministic
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// Merge a state sent by any user into the last known state of the same record
+// Roll20 synchronises the jukebox between all players, so every state is a full snapshot of it.
+// States are applied by date whoever sent them (see the reorder buffer), so the new snapshot wins,
+// only the fields it doesn't carry are kept from the last known state.
+func mergeStates(old, new *R20State) (*R20State, error) {
+	if new == nil {
+		return nil, fmt.Errorf("new state is nil")
+	}
+	merged := &R20State{
+		Uid:          new.Uid,
+		Rid:          new.Rid,
+		Date:         new.Date,
+		ReceivedAt:   new.ReceivedAt,
+		Playlists:    new.Playlists,
+		Tracks:       make([]R20Track, 0, len(new.Tracks)),
+		MasterVolume: new.MasterVolume,
+	}
+	// First state of the record, nothing to reconcile
+	if old == nil {
+		for _, newT := range new.Tracks {
+			newT.LastUpdate = new.ReceivedAt
+			merged.Tracks = append(merged.Tracks, newT)
+		}
+		return merged, nil
+	}
+	if new.Rid != old.Rid {
+		return nil, fmt.Errorf("mismatching state id. Old id %s, new id %s", old.Rid, new.Rid)
+	}
+	// Keep the last known master volume when the state doesn't carry it
+	if merged.MasterVolume == nil {
+		merged.MasterVolume = old.MasterVolume
+	}
+	for _, newT := range new.Tracks {
+		newT.LastUpdate = new.ReceivedAt
+		merged.Tracks = append(merged.Tracks, newT)
+	}
+	return merged, nil
+}
+
+// Restore the previous version of a track in a state, as if the new one was never received
+func revertTrack(new, old *R20State, key string) {
+	tracks := make([]R20Track, 0, len(new.Tracks))
+	for _, t := range new.Tracks {
+		if trackKey(&t) != key {
+			tracks = append(tracks, t)
+		}
+	}
+	if old != nil {
+		if oldT := findMatching(old, key); oldT != nil {
+			tracks = append(tracks, *oldT)
+		}
+	}
+	new.Tracks = tracks
+}
+
+// Check if two states are describing the exact same jukebox, regardless of who sent them and when
+func isSameSnapshot(a, b *R20State) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Rid != b.Rid || len(a.Tracks) != len(b.Tracks) || len(a.Playlists) != len(b.Playlists) {
+		return false
+	}
+	if b.MasterVolume != nil && masterVolume(a) != masterVolume(b) {
+		return false
+	}
+	for _, aP := range a.Playlists {
+		if bP := findPlaylist(b, aP.Id); bP == nil || *bP != aP {
+			return false
+		}
+	}
+	for _, aT := range a.Tracks {
+		bT := findMatching(b, trackKey(&aT))
+		if bT == nil {
+			return false
+		}
+		// Update date and amplitude are only bookkeeping fields
+		bT.LastUpdate = aT.LastUpdate
+		bT.amplitude, bT.hasAmplitude = aT.amplitude, aT.hasAmplitude
+		if *bT != aT {
+			return false
+		}
+	}
+	return true
+}
+
 func stateDelta(old, new *R20State) ([]*pb.Event, error) {
 	if old == nil || new == nil {
 		return nil, fmt.Errorf("At least one state is nil")
@@ -35,18 +163,19 @@ func stateDelta(old, new *R20State) ([]*pb.Event, error) {
 		return nil, fmt.Errorf("expected new state to be newer than old state. Got new : '%s'  | old '%s' ", new.Date, old.Date)
 	}
 
-	// Finally, we can have multiple users sending state changes for the same record
-	if new.Uid != old.Uid {
-		// For now, we're going to consider that a single user have to send the state.
-		// A better implementation would receive the state from multiple user and dedup them
-		return nil, fmt.Errorf("[Jukebox syncer] :: user ID mismatch, multiple users are updating the same record state (Got new : '%s' | old '%s')", new.Uid, old.Uid)
-	}
-
 	var events []*pb.Event
 	for _, newT := range new.Tracks {
-		oldT := findMatching(old, newT.Url)
+		oldT := findMatching(old, trackKey(&newT))
 		events = append(events, trackDelta(oldT, &newT, new.Rid)...)
 	}
+	// Tracks can also be removed from the jukebox, or filtered out by the listener.
+	// As we won't hear from them anymore, they must be stopped, paused ones included.
+	// This also means that an empty state stops everything
+	for _, oldT := range old.Tracks {
+		if (oldT.Playing || isMidTrack(&oldT)) && findMatching(new, trackKey(&oldT)) == nil {
+			events = append(events, makeEvent(&oldT, pb.EventType_STOP, new.Rid))
+		}
+	}
 	return events, nil
 }
 
@@ -56,45 +185,109 @@ func scanForPlay(state *R20State) ([]*pb.Event, error) {
 	}
 	var events []*pb.Event
 	for _, track := range state.Tracks {
-		if track.Playing {
-			// TODO :: Should also seek to the current time
-			events = append(events, makeEvent(&track, pb.EventType_PLAY, state.Rid))
+		if !track.Playing {
+			continue
+		}
+		if evt := makeInitialPlay(&track, state.ReceivedAt, state.Rid); evt != nil {
+			events = append(events, evt)
 		}
 	}
 	return events, nil
 }
 
+// Make a PLAY event for a track that was already playing before we knew about it,
+// given when the state describing the track was received.
+// Returns nil if the track already ended
+func makeInitialPlay(track *R20Track, receivedAt time.Time, rId string) *pb.Event {
+	pos, playing := currentPosition(track, receivedAt, timeNow())
+	if !playing {
+		slog.Debug(fmt.Sprintf("[Jukebox syncer] :: Ignoring track %s, it already ended", track.Url))
+		return nil
+	}
+	evt := makeEvent(track, pb.EventType_PLAY, rId)
+	setSeekPosition(evt, pos)
+	return evt
+}
+
+// Compute the current position of a playing track.
+// Roll20 progress is the played fraction of the track when the state was sent,
+// so the time elapsed since then must be added. This is measured from when the state was received,
+// the clock of the browser that dated it may be off.
+// Returns false if the track isn't looping and is already over at the given time
+func currentPosition(track *R20Track, receivedAt, now time.Time) (time.Duration, bool) {
+	d, err := parseDuration(track.Duration)
+	if err != nil {
+		if track.Duration != "" {
+			slog.Warn(fmt.Sprintf("[Jukebox syncer] :: Playing track from start, error while parsing duration %s : %v", track.Duration, err))
+		}
+		return 0, true
+	}
+	if d <= 0 {
+		return 0, true
+	}
+	pos := progressPosition(d, track.Progress)
+	if !receivedAt.IsZero() {
+		if age := now.Sub(receivedAt); age > 0 {
+			pos += age
+		}
+	}
+	if pos >= d {
+		if !track.Loop {
+			return 0, false
+		}
+		pos %= d
+	}
+	return pos, true
+}
+
 func trackDelta(old, new *R20Track, rId string) []*pb.Event {
 	var events []*pb.Event
 	// First case, the track is new and playing
 	// This should not happen unless we missed an event
 	if old == nil {
 		if new.Playing {
-			// TODO :: Handle initial seek
-			events = append(events, makeEvent(new, pb.EventType_PLAY, rId))
+			if evt := makeInitialPlay(new, new.LastUpdate, rId); evt != nil {
+				events = append(events, evt)
+			}
 		}
 		// As old is nil, we can't compare anything else
 		return events
 	}
 
 	// Second case, toggle the track play state
+	// Roll20 keeps the progress of a paused track, but resets it when the track is stopped or reaches its end
 	if new.Playing != old.Playing {
-		evtType := pb.EventType_PLAY
-		if !new.Playing {
-			evtType = pb.EventType_STOP
+		switch {
+		case !new.Playing && isMidTrack(new):
+			events = append(events, makeEvent(new, pb.EventType_PAUSE, rId))
+		case !new.Playing:
+			events = append(events, makeEvent(new, pb.EventType_STOP, rId))
+		case isMidTrack(old) && new.Progress > 0:
+			// Also send the position, so the mixer can realign the playhead if needed
+			evt := makeEvent(new, pb.EventType_RESUME, rId)
+			if d, err := parseDuration(new.Duration); err == nil {
+				setSeekPosition(evt, progressPosition(d, new.Progress))
+			}
+			events = append(events, evt)
+		default:
+			events = append(events, makeEvent(new, pb.EventType_PLAY, rId))
 		}
-		events = append(events, makeEvent(new, evtType, rId))
+	} else if !new.Playing && isMidTrack(old) && !isMidTrack(new) {
+		// A paused track stopped afterwards only has its progress reset
+		events = append(events, makeEvent(new, pb.EventType_STOP, rId))
 	}
 
 	// Third case, the track is the same, but the loop state changed
 	if new.Loop != old.Loop {
-		events = append(events, makeEvent(new, pb.EventType_OTHER, rId))
+		events = append(events, makeEvent(new, pb.EventType_LOOP, rId))
 	}
 
-	// Fourth case, the track is the same, but the volume changed
-	if new.Volume != old.Volume {
+	// Fourth case, the track is the same, but the volume changed,
+	// either its own or, for a playing track, the master volume of the jukebox
+	oldAmp, newAmp := trackAmplitude(old), trackAmplitude(new)
+	if new.Volume != old.Volume || (new.Playing && newAmp != oldAmp) {
 		evt := makeEvent(new, pb.EventType_VOLUME, rId)
-		evt.VolumeDeltaDb = computeVolumeDb(old.Volume/100, new.Volume/100)
+		evt.VolumeDeltaDb = computeVolumeDb(oldAmp, newAmp)
 		events = append(events, evt)
 	}
 
@@ -105,7 +298,7 @@ func trackDelta(old, new *R20Track, rId string) []*pb.Event {
 	if new.Playing && old.Playing && new.Progress != old.Progress {
 		if d, err := parseDuration(new.Duration); err == nil {
 			evt := makeEvent(new, pb.EventType_SEEK, rId)
-			evt.SeekPositionSec = int64(d.Seconds() * math.Min(new.Progress, 1))
+			setSeekPosition(evt, progressPosition(d, new.Progress))
 			events = append(events, evt)
 		} else {
 			slog.Warn(fmt.Sprintf("[Jukebox syncer] :: Ignoring SEEK event, error while parsing seek pos %s : %v", new.Duration, err))
@@ -115,6 +308,22 @@ func trackDelta(old, new *R20Track, rId string) []*pb.Event {
 	return events
 }
 
+// Position in a track of the given duration, from the played fraction reported by Roll20
+func progressPosition(d time.Duration, progress float64) time.Duration {
+	return time.Duration(float64(d) * math.Max(0, math.Min(progress, 1)))
+}
+
+// Set the seek position of an event, both in seconds and milliseconds
+func setSeekPosition(evt *pb.Event, pos time.Duration) {
+	evt.SeekPositionSec = int64(pos.Seconds())
+	evt.SeekPositionMs = pos.Milliseconds()
+}
+
+// Check if a track has been started, but hasn't reached its end
+func isMidTrack(track *R20Track) bool {
+	return track.Progress > 0 && track.Progress < 1
+}
+
 // Provided two volume values from 0.001 to 1, compute the difference in decibels
 // Any value out of the range will be clamped to the closest bound
 func computeVolumeDb(old, new float64) float64 {
@@ -151,13 +360,16 @@ func parseDuration(s string) (time.Duration, error) {
 }
 
 func makeEvent(track *R20Track, t pb.EventType, rId string) *pb.Event {
+	// Roll20 doesn't play track at full volume by default
+	volumeDb := computeVolumeDb(1, trackAmplitude(track))
 	return &pb.Event{
-		RecordId: rId,
-		EvtId:    track.Url,
-		Type:     t,
-		AssetUrl: track.Url,
-		Loop:     track.Loop,
-		// Roll20 doesn't play track at full volume by default
-		VolumeDeltaDb: computeVolumeDb(1, track.Volume/100),
+		RecordId:      rId,
+		EvtId:         trackKey(track),
+		Type:          t,
+		AssetUrl:      track.Url,
+		Loop:          track.Loop,
+		VolumeDeltaDb: volumeDb,
+		// Every event carries the volume of the track, so the mixer can't drift even if it misses a change
+		VolumeDb: &volumeDb,
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	pb "roll20-audio-bouncer/proto"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -29,15 +30,57 @@ func TestTrackDelta_PlayState(t *testing.T) {
 	assert.Len(t, evts, 0, "expected 0 event")
 }
 
+// Stopping a track with its progress kept is a pause
+func TestTrackDelta_Pause(t *testing.T) {
+	evts := trackDelta(&R20Track{Playing: true, Progress: 0.4}, &R20Track{Playing: false, Progress: 0.5}, "0")
+	assert.Len(t, evts, 1, "expected 1 event")
+	assert.True(t, evts[0].Type == pb.EventType_PAUSE, "expected pause event")
+}
+
+// A track reaching its end, or with its progress reset is stopped
+func TestTrackDelta_StopOrEnd(t *testing.T) {
+	evts := trackDelta(&R20Track{Playing: true, Progress: 0.4}, &R20Track{Playing: false, Progress: 0}, "0")
+	assert.Len(t, evts, 1, "expected 1 event")
+	assert.True(t, evts[0].Type == pb.EventType_STOP, "expected stop event")
+	evts = trackDelta(&R20Track{Playing: true, Progress: 0.99}, &R20Track{Playing: false, Progress: 1}, "0")
+	assert.Len(t, evts, 1, "expected 1 event")
+	assert.True(t, evts[0].Type == pb.EventType_STOP, "expected stop event")
+}
+
+// Stopping a paused track only resets its progress
+func TestTrackDelta_StopAfterPause(t *testing.T) {
+	evts := trackDelta(&R20Track{Playing: false, Progress: 0.5}, &R20Track{Playing: false, Progress: 0}, "0")
+	assert.Len(t, evts, 1, "expected 1 event")
+	assert.True(t, evts[0].Type == pb.EventType_STOP, "expected stop event")
+	evts = trackDelta(&R20Track{Playing: false, Progress: 0.5}, &R20Track{Playing: false, Progress: 0.5}, "0")
+	assert.Len(t, evts, 0, "expected 0 event")
+}
+
+func TestTrackDelta_Resume(t *testing.T) {
+	evts := trackDelta(&R20Track{Playing: false, Progress: 0.5}, &R20Track{Playing: true, Progress: 0.5, Duration: "100"}, "0")
+	assert.Len(t, evts, 1, "expected 1 event")
+	assert.True(t, evts[0].Type == pb.EventType_RESUME, "expected resume event")
+	assert.Equal(t, int64(50), evts[0].SeekPositionSec)
+}
+
+// A paused track restarted from the beginning is played again
+func TestTrackDelta_PlayAfterPause(t *testing.T) {
+	evts := trackDelta(&R20Track{Playing: false, Progress: 0.5}, &R20Track{Playing: true, Progress: 0}, "0")
+	assert.Len(t, evts, 1, "expected 1 event")
+	assert.True(t, evts[0].Type == pb.EventType_PLAY, "expected play event")
+}
+
 func TestTrackDelta_LoopState(t *testing.T) {
 	evts := trackDelta(&R20Track{Loop: true}, &R20Track{Loop: true}, "0")
 	assert.Len(t, evts, 0, "expected 0 event")
 	evts = trackDelta(&R20Track{Loop: true}, &R20Track{Loop: false}, "0")
 	assert.Len(t, evts, 1, "expected 1 event")
-	assert.True(t, evts[0].Type == pb.EventType_OTHER, "expected other event")
+	assert.True(t, evts[0].Type == pb.EventType_LOOP, "expected loop event")
+	assert.False(t, evts[0].Loop, "expected loop to be disabled")
 	evts = trackDelta(&R20Track{Loop: false}, &R20Track{Loop: true}, "0")
 	assert.Len(t, evts, 1, "expected 1 event")
-	assert.True(t, evts[0].Type == pb.EventType_OTHER, "expected other event")
+	assert.True(t, evts[0].Type == pb.EventType_LOOP, "expected loop event")
+	assert.True(t, evts[0].Loop, "expected loop to be enabled")
 	evts = trackDelta(&R20Track{Loop: false}, &R20Track{Loop: false}, "0")
 	assert.Len(t, evts, 0, "expected 0 event")
 }
@@ -74,6 +117,17 @@ func TestStateDelta_PlayState(t *testing.T) {
 	assert.Len(t, evts, 0)
 }
 
+func TestStateDelta_PauseAndResume(t *testing.T) {
+	evts, err := stateDelta(&R20State{Tracks: []R20Track{{Url: "a", Playing: true, Progress: 0.2}}}, &R20State{Tracks: []R20Track{{Url: "a", Playing: false, Progress: 0.3}}})
+	assert.NoError(t, err)
+	assert.Len(t, evts, 1)
+	assert.True(t, evts[0].Type == pb.EventType_PAUSE, "expected pause event")
+	evts, err = stateDelta(&R20State{Tracks: []R20Track{{Url: "a", Playing: false, Progress: 0.3}}}, &R20State{Tracks: []R20Track{{Url: "a", Playing: true, Progress: 0.3}}})
+	assert.NoError(t, err)
+	assert.Len(t, evts, 1)
+	assert.True(t, evts[0].Type == pb.EventType_RESUME, "expected resume event")
+}
+
 func TestStateDelta_LoopState(t *testing.T) {
 	evts, err := stateDelta(&R20State{Tracks: []R20Track{{Url: "a", Loop: true}}}, &R20State{Tracks: []R20Track{{Url: "a", Loop: true}}})
 	assert.NoError(t, err)
@@ -81,16 +135,66 @@ func TestStateDelta_LoopState(t *testing.T) {
 	evts, err = stateDelta(&R20State{Tracks: []R20Track{{Url: "a", Loop: true}}}, &R20State{Tracks: []R20Track{{Url: "a", Loop: false}}})
 	assert.NoError(t, err)
 	assert.Len(t, evts, 1)
-	assert.True(t, evts[0].Type == pb.EventType_OTHER, "expected other event")
+	assert.True(t, evts[0].Type == pb.EventType_LOOP, "expected loop event")
 	evts, err = stateDelta(&R20State{Tracks: []R20Track{{Url: "a", Loop: false}}}, &R20State{Tracks: []R20Track{{Url: "a", Loop: true}}})
 	assert.NoError(t, err)
 	assert.Len(t, evts, 1)
-	assert.True(t, evts[0].Type == pb.EventType_OTHER, "expected other event")
+	assert.True(t, evts[0].Type == pb.EventType_LOOP, "expected loop event")
 	evts, err = stateDelta(&R20State{Tracks: []R20Track{{Url: "a", Loop: false}}}, &R20State{Tracks: []R20Track{{Url: "a", Loop: false}}})
 	assert.NoError(t, err)
 	assert.Len(t, evts, 0)
 }
 
+func TestStateDelta_TrackRemoved(t *testing.T) {
+	evts, err := stateDelta(&R20State{Tracks: []R20Track{{Url: "a", Playing: true}, {Url: "b", Playing: false}}}, &R20State{Tracks: []R20Track{{Url: "c", Playing: false}}})
+	assert.NoError(t, err)
+	assert.Len(t, evts, 1)
+	assert.True(t, evts[0].Type == pb.EventType_STOP, "expected stop event")
+	assert.Equal(t, "a", evts[0].AssetUrl)
+}
+
+// The mixer must not keep the voice of a paused track that was removed
+func TestStateDelta_PausedTrackRemoved(t *testing.T) {
+	evts, err := stateDelta(&R20State{Tracks: []R20Track{{Url: "a", Playing: false, Progress: 0.5}}}, &R20State{Tracks: []R20Track{}})
+	assert.NoError(t, err)
+	assert.Len(t, evts, 1)
+	assert.True(t, evts[0].Type == pb.EventType_STOP, "expected stop event")
+	assert.Equal(t, "a", evts[0].AssetUrl)
+}
+
+func TestStateDelta_EmptyStateStopsEverything(t *testing.T) {
+	evts, err := stateDelta(&R20State{Tracks: []R20Track{{Url: "a", Playing: true}, {Url: "b", Playing: true}}}, &R20State{Tracks: []R20Track{}})
+	assert.NoError(t, err)
+	assert.Len(t, evts, 2)
+	for _, evt := range evts {
+		assert.True(t, evt.Type == pb.EventType_STOP, "expected stop event")
+	}
+}
+
+// A re-signed URL must not be seen as a new track
+func TestStateDelta_UrlSignedAgain(t *testing.T) {
+	evts, err := stateDelta(
+		&R20State{Tracks: []R20Track{{Url: "https://cdn.test/a.mp3?Expires=1&Signature=abc", Playing: true}}},
+		&R20State{Tracks: []R20Track{{Url: "https://cdn.test/a.mp3?Expires=2&Signature=def", Playing: true}}},
+	)
+	assert.NoError(t, err)
+	assert.Len(t, evts, 0)
+}
+
+// The same asset can be used in two different playlists
+func TestStateDelta_SameAssetInTwoPlaylists(t *testing.T) {
+	evts, err := stateDelta(
+		&R20State{Tracks: []R20Track{{Url: "a", TrackId: "t", PlaylistId: "p1", Playing: true}, {Url: "a", TrackId: "t", PlaylistId: "p2", Playing: false}}},
+		&R20State{Tracks: []R20Track{{Url: "a", TrackId: "t", PlaylistId: "p1", Playing: false}, {Url: "a", TrackId: "t", PlaylistId: "p2", Playing: true}}},
+	)
+	assert.NoError(t, err)
+	assert.Len(t, evts, 2)
+	assert.Equal(t, "p1/t", evts[0].EvtId)
+	assert.True(t, evts[0].Type == pb.EventType_STOP, "expected stop event")
+	assert.Equal(t, "p2/t", evts[1].EvtId)
+	assert.True(t, evts[1].Type == pb.EventType_PLAY, "expected play event")
+}
+
 func TestStateDelta_NewStateIsOlder(t *testing.T) {
 	refDate := time.Now()
 	oldS := &R20State{
@@ -104,16 +208,20 @@ func TestStateDelta_NewStateIsOlder(t *testing.T) {
 	assert.Nil(t, evts)
 }
 
+// Multiple users can update the same record
 func TestStateDelta_MismatchingUid(t *testing.T) {
 	oldS := &R20State{
-		Uid: "a",
+		Uid:    "a",
+		Tracks: []R20Track{{Url: "a", Playing: false}},
 	}
 	newS := &R20State{
-		Uid: "b",
+		Uid:    "b",
+		Tracks: []R20Track{{Url: "a", Playing: true}},
 	}
 	evts, err := stateDelta(oldS, newS)
-	assert.Error(t, err)
-	assert.Nil(t, evts)
+	assert.NoError(t, err)
+	assert.Len(t, evts, 1)
+	assert.True(t, evts[0].Type == pb.EventType_PLAY, "expected play event")
 }
 
 func TestStateDelta_MismatchingRid(t *testing.T) {
@@ -128,6 +236,95 @@ func TestStateDelta_MismatchingRid(t *testing.T) {
 	assert.Nil(t, evts)
 }
 
+func TestMergeStates_NewIsNil(t *testing.T) {
+	merged, err := mergeStates(&R20State{}, nil)
+	assert.Error(t, err)
+	assert.Nil(t, merged)
+}
+
+func TestMergeStates_MismatchingRid(t *testing.T) {
+	merged, err := mergeStates(&R20State{Rid: "a"}, &R20State{Rid: "b"})
+	assert.Error(t, err)
+	assert.Nil(t, merged)
+}
+
+func TestMergeStates_OldIsNil(t *testing.T) {
+	refDate := time.Now()
+	merged, err := mergeStates(nil, &R20State{Uid: "a", Date: refDate, ReceivedAt: refDate, Tracks: []R20Track{{Url: "a", Playing: true}}})
+	assert.NoError(t, err)
+	assert.Len(t, merged.Tracks, 1)
+	assert.Equal(t, refDate, merged.Tracks[0].LastUpdate)
+}
+
+// The new snapshot replaces the tracks of the last known one
+func TestMergeStates_NewSnapshotWins(t *testing.T) {
+	refDate := time.Now()
+	old := &R20State{
+		Uid:  "a",
+		Date: refDate,
+		Tracks: []R20Track{
+			{Url: "a", Playing: true, LastUpdate: refDate},
+			{Url: "b", Playing: false, LastUpdate: refDate},
+		},
+	}
+	new := &R20State{
+		Uid:        "b",
+		Date:       refDate.Add(1 * time.Second),
+		ReceivedAt: refDate.Add(2 * time.Second),
+		Tracks:     []R20Track{{Url: "b", Playing: true}},
+	}
+	merged, err := mergeStates(old, new)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", merged.Uid)
+	assert.Len(t, merged.Tracks, 1)
+	assert.True(t, findMatching(merged, "b").Playing, "expected track b to be updated")
+	assert.Equal(t, new.ReceivedAt, findMatching(merged, "b").LastUpdate)
+}
+
+func TestRevertTrack(t *testing.T) {
+	old := &R20State{Tracks: []R20Track{{Url: "a", Playing: false}, {Url: "c", Playing: true}}}
+	new := &R20State{Tracks: []R20Track{{Url: "a", Playing: true}, {Url: "b", Playing: true}}}
+	revertTrack(new, old, "a")
+	assert.False(t, findMatching(new, "a").Playing)
+	// New track
+	revertTrack(new, old, "b")
+	assert.Nil(t, findMatching(new, "b"))
+	// Removed track
+	revertTrack(new, old, "c")
+	assert.True(t, findMatching(new, "c").Playing)
+	assert.Len(t, new.Tracks, 2)
+	revertTrack(new, nil, "a")
+	assert.Len(t, new.Tracks, 1)
+}
+
+func TestIsSameSnapshot(t *testing.T) {
+	refDate := time.Now()
+	a := &R20State{Uid: "a", Date: refDate, Tracks: []R20Track{{Url: "a", Playing: true, LastUpdate: refDate}, {Url: "b"}}}
+	b := &R20State{Uid: "b", Date: refDate.Add(time.Second), Tracks: []R20Track{{Url: "b"}, {Url: "a", Playing: true}}}
+	assert.True(t, isSameSnapshot(a, b))
+	b.Tracks[0].Volume = 50
+	assert.False(t, isSameSnapshot(a, b))
+	b.Tracks[0].Volume = 0
+	b.Playlists = []R20Playlist{{Id: "p", Mode: PlaylistModeShuffle}}
+	assert.False(t, isSameSnapshot(a, b))
+	assert.False(t, isSameSnapshot(a, &R20State{}))
+	assert.False(t, isSameSnapshot(a, nil))
+}
+
+func TestTrackKey(t *testing.T) {
+	assert.Equal(t, "a", trackKey(&R20Track{Url: "a"}))
+	assert.Equal(t, "t", trackKey(&R20Track{Url: "a", TrackId: "t"}))
+	assert.Equal(t, "p/t", trackKey(&R20Track{Url: "a", TrackId: "t", PlaylistId: "p"}))
+}
+
+func TestNormalizeUrl(t *testing.T) {
+	assert.Equal(t, "a", normalizeUrl("a"))
+	assert.Equal(t, "https://cdn.test/a.mp3", normalizeUrl("https://cdn.test/a.mp3?Expires=1&Signature=abc&Key-Pair-Id=k"))
+	assert.Equal(t, "https://cdn.test/a.mp3?v=2", normalizeUrl("https://cdn.test/a.mp3?X-Amz-Date=1&v=2&X-Amz-Signature=abc"))
+	// Stable parameters must be kept in a deterministic order
+	assert.Equal(t, normalizeUrl("https://cdn.test/a.mp3?b=1&a=2"), normalizeUrl("https://cdn.test/a.mp3?a=2&b=1"))
+}
+
 func TestScanForPlay_StateNil(t *testing.T) {
 	evts, err := scanForPlay(nil)
 	assert.Error(t, err)
@@ -153,6 +350,82 @@ func TestScanForPlay_TrackPlaying(t *testing.T) {
 	assert.True(t, evts[0].Type == pb.EventType_PLAY, "expected play event")
 }
 
+// A track already playing must start at its current position, accounting for the state age
+func TestScanForPlay_InitialSeek(t *testing.T) {
+	refDate := time.Now()
+	defer mockNow(refDate)()
+	evts, err := scanForPlay(&R20State{
+		ReceivedAt: refDate.Add(-10 * time.Second),
+		Tracks:     []R20Track{{Url: "a", Playing: true, Progress: 0.5, Duration: "1:40"}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, evts, 1)
+	assert.Equal(t, int64(60), evts[0].SeekPositionSec)
+	assert.Equal(t, int64(60000), evts[0].SeekPositionMs)
+}
+
+// The age of a state is measured on the clock of the syncer, whatever the clock of the browser says
+func TestScanForPlay_BrowserClockSkew(t *testing.T) {
+	refDate := time.Now()
+	defer mockNow(refDate)()
+	evts, err := scanForPlay(&R20State{
+		Date:       refDate.Add(time.Hour),
+		ReceivedAt: refDate.Add(-10 * time.Second),
+		Tracks:     []R20Track{{Url: "a", Playing: true, Progress: 0.5, Duration: "1:40"}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, evts, 1)
+	assert.Equal(t, int64(60), evts[0].SeekPositionSec)
+}
+
+func TestScanForPlay_TrackAlreadyEnded(t *testing.T) {
+	refDate := time.Now()
+	defer mockNow(refDate)()
+	evts, err := scanForPlay(&R20State{
+		ReceivedAt: refDate.Add(-60 * time.Second),
+		Tracks:     []R20Track{{Url: "a", Playing: true, Progress: 0.5, Duration: "1:40"}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, evts, 0)
+}
+
+// A looping track never ends, the position must wrap around
+func TestScanForPlay_LoopingTrackWraps(t *testing.T) {
+	refDate := time.Now()
+	defer mockNow(refDate)()
+	evts, err := scanForPlay(&R20State{
+		ReceivedAt: refDate.Add(-60 * time.Second),
+		Tracks:     []R20Track{{Url: "a", Playing: true, Loop: true, Progress: 0.5, Duration: "1:40"}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, evts, 1)
+	assert.Equal(t, int64(10), evts[0].SeekPositionSec)
+}
+
+func TestTrackDelta_OldIsNilInitialSeek(t *testing.T) {
+	refDate := time.Now()
+	defer mockNow(refDate)()
+	evts := trackDelta(nil, &R20Track{Playing: true, Progress: 0.25, Duration: "100", LastUpdate: refDate}, "0")
+	assert.Len(t, evts, 1)
+	assert.Equal(t, int64(25), evts[0].SeekPositionSec)
+}
+
+// The seek position keeps its sub-second part in milliseconds
+func TestTrackDelta_SeekMilliseconds(t *testing.T) {
+	old := &R20Track{Playing: true, Progress: 0.1, Duration: "10"}
+	evts := trackDelta(old, &R20Track{Playing: true, Progress: 0.25, Duration: "10"}, "0")
+	assert.Len(t, evts, 1)
+	assert.Equal(t, pb.EventType_SEEK, evts[0].Type)
+	assert.Equal(t, int64(2), evts[0].SeekPositionSec)
+	assert.Equal(t, int64(2500), evts[0].SeekPositionMs)
+}
+
+func TestCurrentPosition_InvalidDuration(t *testing.T) {
+	pos, playing := currentPosition(&R20Track{Progress: 0.5, Duration: "a"}, time.Now(), time.Now())
+	assert.True(t, playing)
+	assert.Zero(t, pos)
+}
+
 func TestComputeVolumeDb_Zero(t *testing.T) {
 	val := computeVolumeDb(0, 0)
 	assert.Zero(t, val)
@@ -237,3 +510,37 @@ func FuzzComputeVolumeDb(f *testing.F) {
 		computeVolumeDb(old, new)
 	})
 }
+
+// Current time set by mockNow. The workers of the records read the time concurrently, so it is swapped atomically
+var mockedNow atomic.Pointer[time.Time]
+
+func init() {
+	timeNow = func() time.Time {
+		if now := mockedNow.Load(); now != nil {
+			return *now
+		}
+		return time.Now()
+	}
+}
+
+// Freeze the current time, returning a function restoring it
+func mockNow(now time.Time) func() {
+	mockedNow.Store(&now)
+	return func() { mockedNow.Store(nil) }
+}
+
+// Every event carries the absolute volume of its track, unlike the delta of VOLUME events
+func TestTrackDelta_AbsoluteVolume(t *testing.T) {
+	events := trackDelta(&R20Track{Url: "a", Playing: true, Volume: 50}, &R20Track{Url: "a", Playing: true, Volume: 25}, "1")
+	assert.Len(t, events, 1)
+	assert.Equal(t, pb.EventType_VOLUME, events[0].Type)
+	assert.InDelta(t, computeVolumeDb(0.5, 0.25), events[0].VolumeDeltaDb, 1e-9)
+	assert.InDelta(t, computeVolumeDb(1, 0.25), events[0].GetVolumeDb(), 1e-9)
+
+	evt := makeEvent(&R20Track{Url: "a", Volume: 50}, pb.EventType_PLAY, "1")
+	assert.Equal(t, evt.VolumeDeltaDb, evt.GetVolumeDb())
+	// A track at full scale is at 0 dB, which must be told apart from a missing volume
+	evt = makeEvent(&R20Track{Url: "a", Volume: 100}, pb.EventType_PLAY, "1")
+	assert.NotNil(t, evt.VolumeDb)
+	assert.Equal(t, 0.0, evt.GetVolumeDb())
+}
@@ -0,0 +1,144 @@
+package jukebox_syncer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Maps the position of a Roll20 volume slider, from 0 to 1, to an amplitude from 0 to 1
+type VolumeCurve func(position float64) float64
+
+// The slider position is the amplitude
+func LinearVolume(position float64) float64 {
+	return clamp(position)
+}
+
+// The slider position is perceived loudness, closer to what players hear in the Roll20 player
+func QuadraticVolume(position float64) float64 {
+	p := clamp(position)
+	return p * p
+}
+
+// Build a curve from the amplitudes of evenly spaced slider positions, from 0 to 1.
+// Amplitudes between two positions are interpolated linearly
+func LookupVolume(amplitudes []float64) (VolumeCurve, error) {
+	if len(amplitudes) < 2 {
+		return nil, fmt.Errorf("a volume lookup table needs at least 2 values, got %d", len(amplitudes))
+	}
+	for _, a := range amplitudes {
+		if a < 0 || a > 1 {
+			return nil, fmt.Errorf("volume lookup table values must be between 0 and 1, got %f", a)
+		}
+	}
+	table := append([]float64(nil), amplitudes...)
+	return func(position float64) float64 {
+		x := clamp(position) * float64(len(table)-1)
+		i := int(math.Floor(x))
+		if i >= len(table)-1 {
+			return table[len(table)-1]
+		}
+		return table[i] + (table[i+1]-table[i])*(x-float64(i))
+	}, nil
+}
+
+// Parse a volume curve : either linear, quadratic, or lut: followed by comma separated amplitudes
+func ParseVolumeCurve(spec string) (VolumeCurve, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case spec == "linear":
+		return LinearVolume, nil
+	case spec == "quadratic":
+		return QuadraticVolume, nil
+	case strings.HasPrefix(spec, "lut:"):
+		var amplitudes []float64
+		for _, v := range strings.Split(strings.TrimPrefix(spec, "lut:"), ",") {
+			a, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid volume lookup table value %s : %w", v, err)
+			}
+			amplitudes = append(amplitudes, a)
+		}
+		return LookupVolume(amplitudes)
+	default:
+		return nil, fmt.Errorf("unknown volume curve %s", spec)
+	}
+}
+
+// Parse the volume curves of campaigns, as semicolon separated campaign=curve pairs
+func ParseCampaignVolumeCurves(spec string) (map[string]VolumeCurve, error) {
+	curves := map[string]VolumeCurve{}
+	for _, pair := range strings.Split(spec, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		id, curveSpec, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid campaign volume curve %s, expected campaign=curve", pair)
+		}
+		curve, err := ParseVolumeCurve(curveSpec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid volume curve of campaign %s : %w", id, err)
+		}
+		curves[strings.TrimSpace(id)] = curve
+	}
+	return curves, nil
+}
+
+// Map the Roll20 volume sliders with the given curve, unless the campaign has its own
+func WithVolumeCurve(curve VolumeCurve) Option {
+	return func(es *JukeboxSyncer) {
+		es.volumeCurve = curve
+	}
+}
+
+// Map the Roll20 volume sliders of a campaign with the given curve
+func WithCampaignVolumeCurve(id string, curve VolumeCurve) Option {
+	return func(es *JukeboxSyncer) {
+		es.campaignVolumeCurves[id] = curve
+	}
+}
+
+// Curve used to map the volume sliders of a campaign
+func (es *JukeboxSyncer) curveOf(id string) VolumeCurve {
+	if curve, ok := es.campaignVolumeCurves[id]; ok {
+		return curve
+	}
+	if es.volumeCurve != nil {
+		return es.volumeCurve
+	}
+	return LinearVolume
+}
+
+// Compute the amplitude of each track of a state, including the jukebox master volume
+func applyVolumeCurve(state *R20State, curve VolumeCurve) {
+	if state == nil {
+		return
+	}
+	master := curve(masterVolume(state) / 100)
+	for i := range state.Tracks {
+		state.Tracks[i].amplitude = curve(state.Tracks[i].Volume/100) * master
+		state.Tracks[i].hasAmplitude = true
+	}
+}
+
+// Master volume of the jukebox, older listeners don't send it
+func masterVolume(state *R20State) float64 {
+	if state.MasterVolume == nil {
+		return 100
+	}
+	return *state.MasterVolume
+}
+
+// Amplitude of a track from 0 to 1. Without a volume curve applied, the slider is considered linear
+func trackAmplitude(track *R20Track) float64 {
+	if track.hasAmplitude {
+		return track.amplitude
+	}
+	return track.Volume / 100
+}
+
+func clamp(v float64) float64 {
+	return math.Max(0, math.Min(v, 1))
+}
@@ -0,0 +1,89 @@
+package jukebox_syncer
+
+import (
+	"github.com/stretchr/testify/assert"
+	pb "roll20-audio-bouncer/proto"
+	"testing"
+	"time"
+)
+
+func TestVolumeCurves(t *testing.T) {
+	assert.Equal(t, 0.5, LinearVolume(0.5))
+	assert.Equal(t, 0.25, QuadraticVolume(0.5))
+	// Out of range positions are clamped
+	assert.Equal(t, 1.0, QuadraticVolume(2))
+	assert.Equal(t, 0.0, LinearVolume(-1))
+}
+
+func TestLookupVolume(t *testing.T) {
+	curve, err := LookupVolume([]float64{0, 0.2, 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, curve(0))
+	assert.InDelta(t, 0.1, curve(0.25), 1e-9)
+	assert.InDelta(t, 0.2, curve(0.5), 1e-9)
+	assert.InDelta(t, 0.6, curve(0.75), 1e-9)
+	assert.Equal(t, 1.0, curve(1))
+
+	_, err = LookupVolume([]float64{1})
+	assert.Error(t, err)
+	_, err = LookupVolume([]float64{0, 1.5})
+	assert.Error(t, err)
+}
+
+func TestParseVolumeCurve(t *testing.T) {
+	curve, err := ParseVolumeCurve("quadratic")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.25, curve(0.5))
+	curve, err = ParseVolumeCurve("lut: 0, 0.5, 1")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.5, curve(0.5))
+	_, err = ParseVolumeCurve("lut:0,a")
+	assert.Error(t, err)
+	_, err = ParseVolumeCurve("cubic")
+	assert.Error(t, err)
+}
+
+func TestParseCampaignVolumeCurves(t *testing.T) {
+	curves, err := ParseCampaignVolumeCurves("1=quadratic; 2=lut:0,1;")
+	assert.NoError(t, err)
+	assert.Len(t, curves, 2)
+	assert.Equal(t, 0.25, curves["1"](0.5))
+	_, err = ParseCampaignVolumeCurves("1")
+	assert.Error(t, err)
+	_, err = ParseCampaignVolumeCurves("1=cubic")
+	assert.Error(t, err)
+}
+
+// The volume of a track goes through the curve of its campaign
+func TestJukeboxSyncer_CampaignVolumeCurve(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m, WithCampaignVolumeCurve("1", QuadraticVolume))
+	for _, id := range []string{"1", "2"} {
+		assert.NoError(t, s.Start(id))
+		assert.NoError(t, s.Handle(&R20State{Rid: id, Tracks: []R20Track{{Url: "a", Playing: true, Volume: 50}}}))
+		waitIdle(t, s, id)
+	}
+	assert.Len(t, m.events, 2)
+	assert.InDelta(t, computeVolumeDb(1, 0.25), m.events[0].VolumeDeltaDb, 1e-9)
+	assert.InDelta(t, computeVolumeDb(1, 0.5), m.events[1].VolumeDeltaDb, 1e-9)
+}
+
+// A change of the master volume changes the volume of every playing track
+func TestJukeboxSyncer_MasterVolume(t *testing.T) {
+	m := &mockMixer{}
+	s := NewJukeboxSyncer(m)
+	assert.NoError(t, s.Start("1"))
+	refDate := time.Now()
+	tracks := []R20Track{{Url: "a", Playing: true, Volume: 50}, {Url: "b", Playing: true, Volume: 100}, {Url: "c", Volume: 100}}
+	full, half := 100.0, 50.0
+	assert.NoError(t, s.Handle(&R20State{Rid: "1", Date: refDate, MasterVolume: &full, Tracks: tracks}))
+	assert.NoError(t, s.Handle(&R20State{Rid: "1", Date: refDate.Add(time.Second), MasterVolume: &half, Tracks: tracks}))
+	// A state without master volume keeps the last known one
+	assert.NoError(t, s.Handle(&R20State{Rid: "1", Date: refDate.Add(2 * time.Second), Tracks: tracks}))
+	waitIdle(t, s, "1")
+	assert.Len(t, m.events, 4)
+	for _, evt := range m.events[2:] {
+		assert.Equal(t, pb.EventType_VOLUME, evt.Type)
+		assert.InDelta(t, computeVolumeDb(1, 0.5), evt.VolumeDeltaDb, 1e-9)
+	}
+}